@@ -2,29 +2,51 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
-	"io/ioutil"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gojuno/aleh"
-	"olympos.io/encoding/edn"
 )
 
-var configFile = flag.String("c", "etc/config.edn", "pass path to Config file")
+var (
+	configFile  = flag.String("c", "etc/config.edn", "pass path to Config file")
+	watchConfig = flag.Duration("watch-config", 0, "period of checking Config file for changes to reload it, disabled when zero")
+	checkConfig = flag.Bool("check-config", false, "validate Config, print the effective Config and exit")
+)
 
 func main() {
-	c := readConfig()
+	flag.Parse()
+	c, err := aleh.ReadConfig(*configFile)
+	if *checkConfig {
+		check(c, err)
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 	log.Printf("starting with Config %+v", c)
 
 	ctx := context.Background()
 
+	s, err := aleh.NewServer(ctx, c, aleh.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
 	httpServer := &http.Server{
 		Addr:    c.Endpoint,
-		Handler: aleh.New(ctx, c),
+		Handler: s,
+	}
+
+	go reloadOnSignal(s)
+	if *watchConfig > 0 {
+		go reloadOnChange(s, *watchConfig)
 	}
 
 	go func() {
@@ -35,6 +57,9 @@ func main() {
 	}()
 
 	graceful(ctx, httpServer)
+	if err := s.Close(); err != nil {
+		log.Printf("Error: failed to close: %v\n", err)
+	}
 }
 
 func graceful(ctx context.Context, httpServer *http.Server) {
@@ -48,34 +73,54 @@ func graceful(ctx context.Context, httpServer *http.Server) {
 	}
 }
 
-func readConfig() aleh.Config {
-	flag.Parse()
-	cfg, err := os.Open(*configFile)
+func reload(s *aleh.Server) {
+	log.Printf("reloading Config %s", *configFile)
+	err := s.Reload(func() (aleh.Config, error) {
+		return aleh.ReadConfig(*configFile)
+	})
 	if err != nil {
-		log.Fatalf("failed to open Config file %s: %v", *configFile, err)
+		log.Printf("Error: failed to reload Config: %v", err)
+		return
 	}
-	defer cfg.Close()
+	log.Printf("Config reloaded")
+}
 
-	configData, err := ioutil.ReadAll(cfg)
-	if err != nil {
-		log.Fatalf("failed to read Config file %s: %v", *configFile, err)
+func reloadOnSignal(s *aleh.Server) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		reload(s)
 	}
-	c := aleh.Config{}
+}
 
-	if err := edn.Unmarshal(configData, &c); err != nil {
-		log.Fatalf("failed to unmarshal Config %s file %s: %v", *configFile, string(configData), err)
+func reloadOnChange(s *aleh.Server, period time.Duration) {
+	modTime := func() time.Time {
+		fi, err := os.Stat(*configFile)
+		if err != nil {
+			log.Printf("Error: failed to stat Config file %s: %v", *configFile, err)
+			return time.Time{}
+		}
+		return fi.ModTime()
 	}
 
-	if c.MetricPrefix == "" {
-		c.MetricPrefix = "aleh_"
+	last := modTime()
+	for range time.Tick(period) {
+		if t := modTime(); !t.IsZero() && !t.Equal(last) {
+			last = t
+			reload(s)
+		}
 	}
+}
 
-	if c.DockerDaemonSocket == "" {
-		c.DockerDaemonSocket = "/var/run/docker.sock"
+func check(c aleh.Config, err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-
-	if c.Endpoint == "" {
-		c.Endpoint = "0.0.0.0:1234"
+	out, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	return c
+	fmt.Println(string(out))
 }
@@ -1,45 +1,146 @@
 package collectors
 
 import (
+	"log"
+
 	"github.com/gojuno/aleh/storages"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type ContainerInfo struct {
-	SkipRunning *bool `edn:"skip-running"`
+	SkipRunning *bool `edn:"skip-running" json:"skip-running" yaml:"skip-running"`
+	// Min and Max are expected amounts of running replicas,
+	// the container_running zero sample is not reported when any of them is set
+	Min *int `edn:"min" json:"min" yaml:"min"`
+	Max *int `edn:"max" json:"max" yaml:"max"`
+}
+
+// ValidateServices checks patterns of static services config.
+func ValidateServices(services map[string]map[string]ContainerInfo) error {
+	_, err := compileServices(services)
+	return err
+}
+
+type staticContainer struct {
+	service   namePattern
+	container namePattern
+	info      ContainerInfo
+}
+
+func compileServices(services map[string]map[string]ContainerInfo) ([]staticContainer, error) {
+	res := []staticContainer{}
+	for serviceName, v := range services {
+		service, err := newNamePattern(serviceName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "service %q", serviceName)
+		}
+		for containerName, info := range v {
+			container, err := newNamePattern(containerName)
+			if err != nil {
+				return nil, errors.Wrapf(err, "service %q container %q", serviceName, containerName)
+			}
+			res = append(res, staticContainer{service: service, container: container, info: info})
+		}
+	}
+	return res, nil
 }
 
 // AliveCollector reports to prometheus known containers that is alive.
+// Service and container names of static services may be globs or regular expressions prefixed with RegexpPrefix.
 type AliveCollector struct {
 	desc           *prometheus.Desc
+	pausedDesc     *prometheus.Desc
+	revisionDesc   *prometheus.Desc
+	expectedDesc   *prometheus.Desc
+	runningDesc    *prometheus.Desc
+	missingDesc    *prometheus.Desc
+	unexpectedDesc *prometheus.Desc
 	storage        *storages.InmemoryStorage
-	staticServices map[string]map[string]ContainerInfo
+	labels         *ContainerLabels
+	staticServices []staticContainer
 }
 
-func NewAliveCollector(metricPrefix string, l *storages.InmemoryStorage, services map[string]map[string]ContainerInfo) *AliveCollector {
+func NewAliveCollector(metricPrefix string, l *storages.InmemoryStorage, labels *ContainerLabels, services map[string]map[string]ContainerInfo) *AliveCollector {
+	staticServices, err := compileServices(services)
+	if err != nil {
+		log.Printf("ERROR: invalid static services, ignoring them: %v", err)
+	}
 	return &AliveCollector{
-		staticServices: services,
+		staticServices: staticServices,
 		storage:        l,
-		desc:           prometheus.NewDesc(metricPrefix+"container_running", "Container is alive", []string{"service", "container", "container_id", "revisions"}, nil),
+		labels:         labels,
+		desc:           prometheus.NewDesc(metricPrefix+"container_running", "Container is alive", labels.Names(), nil),
+		pausedDesc:     prometheus.NewDesc(metricPrefix+"container_paused", "Container is paused", labels.Names(), nil),
+		revisionDesc:   prometheus.NewDesc(metricPrefix+"container_revision_info", "Revision of alive container component", []string{"service", "container", "component", "revision"}, nil),
+		expectedDesc:   prometheus.NewDesc(metricPrefix+"expected_replicas", "Expected amount of running container replicas", []string{"service", "container", "bound"}, nil),
+		runningDesc:    prometheus.NewDesc(metricPrefix+"running_replicas", "Amount of running container replicas", []string{"service", "container"}, nil),
+		missingDesc:    prometheus.NewDesc(metricPrefix+"replicas_missing", "Amount of running container replicas below the expected minimum", []string{"service", "container"}, nil),
+		unexpectedDesc: prometheus.NewDesc(metricPrefix+"unexpected_container", "Alive container not matching any of static services", []string{"service", "container", "container_id"}, nil),
 	}
 }
 
 // Describe prometheus.Collector interface implementation
 func (ac *AliveCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- ac.desc
+	ch <- ac.pausedDesc
+	if ac.labels.RevisionsMode() == RevisionsInfo {
+		ch <- ac.revisionDesc
+	}
+	ch <- ac.expectedDesc
+	ch <- ac.runningDesc
+	ch <- ac.missingDesc
+	ch <- ac.unexpectedDesc
 }
 
 // Collect prometheus.Collector interface implementation
 func (ac *AliveCollector) Collect(ch chan<- prometheus.Metric) {
+	revisions := map[[4]string]struct{}{}
+	replicas := make([]int, len(ac.staticServices))
 	for _, c := range ac.storage.AliveECSContainers() {
-		ch <- prometheus.MustNewConstMetric(ac.desc, prometheus.CounterValue, 1.0, c.Service, c.Container, c.ID, c.Revisions)
-	}
-	for serviceName, v := range ac.staticServices {
-		for containerName, info := range v {
-			if info.SkipRunning == nil || !*info.SkipRunning {
-				ch <- prometheus.MustNewConstMetric(ac.desc, prometheus.CounterValue, 0, serviceName, containerName, "", "")
+		matched := false
+		for i, sc := range ac.staticServices {
+			if sc.service.match(c.Service) && sc.container.match(c.Container) {
+				replicas[i]++
+				matched = true
 			}
+		}
+		if !matched && len(ac.staticServices) > 0 {
+			ch <- prometheus.MustNewConstMetric(ac.unexpectedDesc, prometheus.GaugeValue, 1.0, c.Service, c.Container, c.ID)
+		}
 
+		ch <- prometheus.MustNewConstMetric(ac.desc, prometheus.CounterValue, 1.0, ac.labels.Values(c)...)
+		if c.Paused {
+			ch <- prometheus.MustNewConstMetric(ac.pausedDesc, prometheus.GaugeValue, 1.0, ac.labels.Values(c)...)
+		}
+		for component, revision := range c.RevisionsMap {
+			revisions[[4]string{c.Service, c.Container, component, revision}] = struct{}{}
+		}
+	}
+	if ac.labels.RevisionsMode() == RevisionsInfo {
+		// replicas of the same container share revisions, so values are deduplicated
+		for r := range revisions {
+			ch <- prometheus.MustNewConstMetric(ac.revisionDesc, prometheus.GaugeValue, 1.0, r[:]...)
+		}
+	}
+	for i, sc := range ac.staticServices {
+		serviceName, containerName, info := sc.service.raw, sc.container.raw, sc.info
+		if info.Min == nil && info.Max == nil && (info.SkipRunning == nil || !*info.SkipRunning) {
+			ch <- prometheus.MustNewConstMetric(ac.desc, prometheus.CounterValue, 0, ac.labels.EmptyValues(serviceName, containerName)...)
+		}
+
+		running := replicas[i]
+		ch <- prometheus.MustNewConstMetric(ac.runningDesc, prometheus.GaugeValue, float64(running), serviceName, containerName)
+		if info.Min != nil {
+			ch <- prometheus.MustNewConstMetric(ac.expectedDesc, prometheus.GaugeValue, float64(*info.Min), serviceName, containerName, "min")
+			missing := *info.Min - running
+			if missing < 0 {
+				missing = 0
+			}
+			ch <- prometheus.MustNewConstMetric(ac.missingDesc, prometheus.GaugeValue, float64(missing), serviceName, containerName)
+		}
+		if info.Max != nil {
+			ch <- prometheus.MustNewConstMetric(ac.expectedDesc, prometheus.GaugeValue, float64(*info.Max), serviceName, containerName, "max")
 		}
 	}
 }
@@ -0,0 +1,189 @@
+package collectors
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/gojuno/aleh/storages"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ContainerMetric describes a metric emitted by ContainerCollector.
+type ContainerMetric struct {
+	// Name is appended to the metric prefix
+	Name string
+	Help string
+	Type prometheus.ValueType
+	// Labels are names of metric specific labels, they go before container labels
+	Labels []string
+}
+
+// Sample is a value of ContainerMetric.
+type Sample struct {
+	Metric      string
+	Value       float64
+	LabelValues []string
+}
+
+// ContainerCollector collects stats of a single container.
+// Samples are reported with the container label set shared by all per-container collectors, see ContainerLabels.
+type ContainerCollector interface {
+	// Name identifies the collector in config, logs and metrics
+	Name() string
+	// Metrics describes all metrics emitted by the collector
+	Metrics() []ContainerMetric
+	// CollectContainer emits samples of the container. It is called concurrently for different containers,
+	// ctx is cancelled on collection timeout.
+	CollectContainer(ctx context.Context, c storages.Container, emit func(Sample)) error
+}
+
+type metricDesc struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+}
+
+// ContainerStatsCollector reports to prometheus stats of known alive containers collected by ContainerCollector.
+type ContainerStatsCollector struct {
+	collector    ContainerCollector
+	storage      *storages.InmemoryStorage
+	labels       *ContainerLabels
+	opts         ContainerCollectorOptions
+	metrics      map[string]metricDesc
+	errors       prometheus.Counter
+	timeouts     prometheus.Counter
+	cgroupErrors prometheus.Counter
+}
+
+// NewContainerStatsCollector creates ContainerStatsCollector running cc for every alive container matching the filter.
+// It returns error when metrics of cc are not unique or their labels clash with container labels.
+func NewContainerStatsCollector(metricPrefix string, l *storages.InmemoryStorage, labels *ContainerLabels, cc ContainerCollector, opts ContainerCollectorOptions) (*ContainerStatsCollector, error) {
+	constLabels := prometheus.Labels{"collector": cc.Name()}
+	s := &ContainerStatsCollector{
+		collector: cc,
+		storage:   l,
+		labels:    labels,
+		opts:      opts,
+		metrics:   map[string]metricDesc{},
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        metricPrefix + "container_collector_errors_total",
+			Help:        "Amount of failed container stats collections",
+			ConstLabels: constLabels,
+		}),
+		timeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        metricPrefix + "container_collector_timeouts_total",
+			Help:        "Amount of container stats collections skipped because of the collection timeout",
+			ConstLabels: constLabels,
+		}),
+		cgroupErrors: newCgroupErrors(metricPrefix, cc.Name()),
+	}
+	for _, m := range cc.Metrics() {
+		if _, ok := s.metrics[m.Name]; ok {
+			return nil, errors.Errorf("duplicate metric %s of %s collector", m.Name, cc.Name())
+		}
+		if err := checkMetricLabels(m.Labels, labels); err != nil {
+			return nil, errors.Wrapf(err, "invalid metric %s of %s collector", m.Name, cc.Name())
+		}
+		s.metrics[m.Name] = metricDesc{
+			desc:      prometheus.NewDesc(metricPrefix+m.Name, m.Help, labels.Names(m.Labels...), nil),
+			valueType: m.Type,
+		}
+	}
+	return s, nil
+}
+
+// checkMetricLabels returns error for invalid metric specific label names or the ones used by container labels.
+func checkMetricLabels(names []string, labels *ContainerLabels) error {
+	used := map[string]bool{}
+	for _, name := range labels.Names() {
+		used[name] = true
+	}
+	for _, name := range names {
+		if name == "" || sanitizeLabelName(name) != name || strings.HasPrefix(name, "__") {
+			return errors.Errorf("invalid label name %q", name)
+		}
+		if used[name] {
+			return errors.Errorf("label %q is already used", name)
+		}
+		used[name] = true
+	}
+	return nil
+}
+
+// Describe prometheus.Collector interface implementation
+func (s *ContainerStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range s.metrics {
+		ch <- m.desc
+	}
+	ch <- s.errors.Desc()
+	ch <- s.timeouts.Desc()
+	ch <- s.cgroupErrors.Desc()
+}
+
+// Collect prometheus.Collector interface implementation
+func (s *ContainerStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+		defer cancel()
+	}
+
+	skipped, total := forEachContainer(ctx, s.opts.Pool, s.storage.AliveECSContainers(), s.opts.Filter, func(c storages.Container) func() {
+		res := s.collectContainer(ctx, c)
+		return func() {
+			for _, m := range res {
+				ch <- m
+			}
+		}
+	})
+	if skipped > 0 {
+		log.Printf("ERROR: %s collector timed out after %s, skipped %d of %d containers", s.collector.Name(), s.opts.Timeout, skipped, total)
+		s.timeouts.Add(float64(skipped))
+	}
+	s.errors.Collect(ch)
+	s.timeouts.Collect(ch)
+	s.cgroupErrors.Collect(ch)
+}
+
+func (s *ContainerStatsCollector) collectContainer(ctx context.Context, c storages.Container) []prometheus.Metric {
+	var res []prometheus.Metric
+	var emitErr error
+	err := s.collector.CollectContainer(ctx, c, func(sample Sample) {
+		m, err := s.metric(c, sample)
+		if err != nil {
+			emitErr = err
+			return
+		}
+		res = append(res, m)
+	})
+	if err == nil {
+		err = emitErr
+	}
+	if err != nil && ctx.Err() == nil {
+		s.errors.Inc()
+		if _, ok := errors.Cause(err).(CgroupReadError); ok {
+			s.cgroupErrors.Inc()
+		}
+		log.Printf("ERROR: %s collector failed to collect container %s: %v", s.collector.Name(), c.ID, err)
+	}
+	return res
+}
+
+func (s *ContainerStatsCollector) metric(c storages.Container, sample Sample) (prometheus.Metric, error) {
+	m, ok := s.metrics[sample.Metric]
+	if !ok {
+		return nil, errors.Errorf("unknown metric %q", sample.Metric)
+	}
+	return prometheus.NewConstMetric(m.desc, m.valueType, sample.Value, s.labels.Values(c, sample.LabelValues...)...)
+}
+
+func newCgroupErrors(metricPrefix, collector string) prometheus.Counter {
+	return prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        metricPrefix + "cgroup_read_errors_total",
+		Help:        "Amount of failed reads of cgroup pseudo files",
+		ConstLabels: prometheus.Labels{"collector": collector},
+	})
+}
@@ -0,0 +1,54 @@
+package collectors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gojuno/aleh/storages"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type metricsCollector []ContainerMetric
+
+func (mc metricsCollector) Name() string {
+	return "test"
+}
+
+func (mc metricsCollector) Metrics() []ContainerMetric {
+	return mc
+}
+
+func (mc metricsCollector) CollectContainer(ctx context.Context, c storages.Container, emit func(Sample)) error {
+	return nil
+}
+
+func TestContainerStatsCollectorLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics []ContainerMetric
+		err     bool
+	}{
+		{name: "own labels", metrics: []ContainerMetric{{Name: "io", Type: prometheus.CounterValue, Labels: []string{"device", "op"}}}},
+		{name: "no labels", metrics: []ContainerMetric{{Name: "io", Type: prometheus.CounterValue}}},
+		{name: "fixed container label", metrics: []ContainerMetric{{Name: "io", Labels: []string{"service"}}}, err: true},
+		{name: "configured container label", metrics: []ContainerMetric{{Name: "io", Labels: []string{"team"}}}, err: true},
+		{name: "revisions label", metrics: []ContainerMetric{{Name: "io", Labels: []string{"revisions"}}}, err: true},
+		{name: "duplicate label", metrics: []ContainerMetric{{Name: "io", Labels: []string{"device", "device"}}}, err: true},
+		{name: "invalid label", metrics: []ContainerMetric{{Name: "io", Labels: []string{"dev-ice"}}}, err: true},
+		{name: "reserved label", metrics: []ContainerMetric{{Name: "io", Labels: []string{"__name__"}}}, err: true},
+		{name: "duplicate metric", metrics: []ContainerMetric{{Name: "io"}, {Name: "io"}}, err: true},
+	}
+
+	labels := NewContainerLabels("test_", map[string]string{"team": "team"}, 0, "", RevisionsConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewContainerStatsCollector("test_", nil, labels, metricsCollector(tt.metrics), ContainerCollectorOptions{})
+			if tt.err && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -2,14 +2,15 @@ package collectors
 
 import (
 	"bufio"
-	"log"
+	"context"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/gojuno/aleh/storages"
 
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -22,41 +23,50 @@ const (
 	cpuMultiplier = clockTicks / nanosecondsInSecond
 )
 
-// CPUCollector reports to prometheus CPU usage of known alive containers. Data is grabbed from cgroups pseudo cpu stat file.
-type CPUCollector struct {
-	storage *storages.InmemoryStorage
-	desc    *prometheus.Desc
+// CPUCollector collects CPU usage of containers. Data is grabbed from cgroups pseudo cpu stat file.
+type CPUCollector struct{}
+
+func NewCPUCollector() CPUCollector {
+	return CPUCollector{}
 }
 
-func NewCPUCollector(metricPrefix string, l *storages.InmemoryStorage) *CPUCollector {
-	return &CPUCollector{
-		storage: l,
-		desc:    prometheus.NewDesc(metricPrefix+"cgroup_cpu_stats", "Container cpu usage percent", []string{"who", "service", "container", "container_id", "revisions"}, nil),
-	}
+// Name ContainerCollector interface implementation
+func (CPUCollector) Name() string {
+	return "cpu"
 }
 
-// Describe prometheus.Collector interface implementation
-func (cs *CPUCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- cs.desc
+// Metrics ContainerCollector interface implementation
+func (CPUCollector) Metrics() []ContainerMetric {
+	return []ContainerMetric{{Name: "cgroup_cpu_stats", Help: "Container cpu usage percent", Type: prometheus.GaugeValue, Labels: []string{"who"}}}
 }
 
-// Collect prometheus.Collector interface implementation
-func (cs *CPUCollector) Collect(ch chan<- prometheus.Metric) {
-	wg := sync.WaitGroup{}
-	for _, c := range cs.storage.AliveECSContainers() {
-		wg.Add(1)
-		go func(c storages.Container) {
-			defer wg.Done()
-			loadMetric(c, c.CPUStatsPath, cs.desc, ch)
-		}(c)
-	}
-	wg.Wait()
+// CollectContainer ContainerCollector interface implementation
+func (CPUCollector) CollectContainer(_ context.Context, c storages.Container, emit func(Sample)) error {
+	return loadMetric(c.CPUStatsPath, "cgroup_cpu_stats", emit)
+}
+
+// CgroupReadError is returned by ContainerCollector implementations failing to read or parse cgroup pseudo files,
+// such errors are counted separately.
+type CgroupReadError struct {
+	Path string
+	Err  error
 }
 
-func loadMetric(c storages.Container, files []string, desc *prometheus.Desc, ch chan<- prometheus.Metric) {
+func (e CgroupReadError) Error() string {
+	return fmt.Sprintf("failed to read cgroup file %s: %v", e.Path, e.Err)
+}
+
+// loadMetric emits every "name value" line of stat files as a sample of metric labeled with the stat name.
+// Missing files are skipped, other errors are reported as CgroupReadError after reading all files.
+func loadMetric(files []string, metric string, emit func(Sample)) error {
+	var readErr error
 	for _, filePath := range files {
 		file, err := os.Open(filePath)
+		if os.IsNotExist(err) {
+			continue
+		}
 		if err != nil {
+			readErr = CgroupReadError{Path: filePath, Err: err}
 			continue
 		}
 		defer file.Close()
@@ -65,18 +75,22 @@ func loadMetric(c storages.Container, files []string, desc *prometheus.Desc, ch
 		scanner.Buffer([]byte{}, 1024)
 
 		for scanner.Scan() {
-			metric := scanner.Text()
-			statValue := strings.Split(metric, " ")
+			line := scanner.Text()
+			statValue := strings.Split(line, " ")
 			if len(statValue) < 2 {
-				log.Printf("ERROR: corrupted stat %q in file %q", metric, filePath)
+				readErr = CgroupReadError{Path: filePath, Err: errors.Errorf("corrupted stat %q", line)}
 				continue
 			}
 			value, err := strconv.ParseUint(statValue[1], 10, 64)
 			if err != nil {
-				log.Printf("ERROR: corrupted stat %q in file %q cant parse value: %v", metric, filePath, err)
+				readErr = CgroupReadError{Path: filePath, Err: errors.Wrapf(err, "corrupted stat %q cant parse value", line)}
 				continue
 			}
-			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value), statValue[0], c.Service, c.Container, c.ID, c.Revisions)
+			emit(Sample{Metric: metric, Value: float64(value), LabelValues: []string{statValue[0]}})
+		}
+		if err := scanner.Err(); err != nil {
+			readErr = CgroupReadError{Path: filePath, Err: err}
 		}
 	}
+	return readErr
 }
@@ -0,0 +1,164 @@
+package collectors
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gojuno/aleh/storages"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultCrashLoopWindow is the default period restarts are counted within.
+	DefaultCrashLoopWindow = 10 * time.Minute
+	// DefaultCrashLoopThreshold is the default amount of restarts within the window to consider service crashlooping.
+	DefaultCrashLoopThreshold = 3
+	// DefaultStableRun is the default duration of a successful container run.
+	DefaultStableRun = 5 * time.Minute
+)
+
+type serviceRuns struct {
+	// ends of container runs shorter than stable run
+	crashes []time.Time
+	// running containers start times
+	running map[string]time.Time
+	// end of the last successful run or the moment service was seen first time
+	lastStable time.Time
+}
+
+// CrashLoopCollector reports to prometheus services restarting repeatedly. A restart is a container run shorter than
+// stable run, starts of new containers like deploys and scale-ups are not restarts.
+type CrashLoopCollector struct {
+	mu           sync.Mutex
+	window       time.Duration
+	threshold    int
+	stableRun    time.Duration
+	subscription *storages.Subscription
+	services     map[string]*serviceRuns
+
+	restartsDesc     *prometheus.Desc
+	crashloopingDesc *prometheus.Desc
+	sinceStableDesc  *prometheus.Desc
+}
+
+// NewCrashLoopCollector creates CrashLoopCollector. Service is crashlooping when it has at least threshold
+// restarts within window, container run is successful when it lasts at least stableRun.
+func NewCrashLoopCollector(metricPrefix string, l *storages.InmemoryStorage, window time.Duration, threshold int, stableRun time.Duration) *CrashLoopCollector {
+	cc := newCrashLoopCollector(metricPrefix, window, threshold, stableRun)
+	cc.subscription = l.Subscribe("crashloop", storages.SubscribeOptions{
+		Types:    []storages.EventType{storages.ContainerStarted, storages.ContainerDiscovered, storages.ContainerDied},
+		Snapshot: true,
+	})
+
+	go cc.watch()
+	return cc
+}
+
+func newCrashLoopCollector(metricPrefix string, window time.Duration, threshold int, stableRun time.Duration) *CrashLoopCollector {
+	cc := &CrashLoopCollector{
+		services:         map[string]*serviceRuns{},
+		restartsDesc:     prometheus.NewDesc(metricPrefix+"service_restarts_window", "Amount of service container runs shorter than stable run ended within the crashloop window", []string{"service"}, nil),
+		crashloopingDesc: prometheus.NewDesc(metricPrefix+"service_crashlooping", "Service restarts at least threshold times within the crashloop window", []string{"service"}, nil),
+		sinceStableDesc:  prometheus.NewDesc(metricPrefix+"service_seconds_since_stable_run", "Seconds since the last service container run lasting longer than stable run duration", []string{"service"}, nil),
+	}
+	cc.SetSettings(window, threshold, stableRun)
+	return cc
+}
+
+// SetSettings changes crashloop detection settings, zero values are replaced with defaults.
+func (cc *CrashLoopCollector) SetSettings(window time.Duration, threshold int, stableRun time.Duration) {
+	if window <= 0 {
+		window = DefaultCrashLoopWindow
+	}
+	if threshold <= 0 {
+		threshold = DefaultCrashLoopThreshold
+	}
+	if stableRun <= 0 {
+		stableRun = DefaultStableRun
+	}
+	cc.mu.Lock()
+	cc.window, cc.threshold, cc.stableRun = window, threshold, stableRun
+	cc.mu.Unlock()
+}
+
+// Close stops watching container lifecycle events.
+func (cc *CrashLoopCollector) Close() error {
+	cc.subscription.Unsubscribe()
+	return nil
+}
+
+func (cc *CrashLoopCollector) watch() {
+	for e := range cc.subscription.Events() {
+		if !e.Container.Ecs {
+			continue
+		}
+		cc.mu.Lock()
+		cc.handle(e)
+		cc.mu.Unlock()
+	}
+}
+
+func (cc *CrashLoopCollector) handle(e storages.LifecycleEvent) {
+	s, ok := cc.services[e.Container.Service]
+	if !ok {
+		s = &serviceRuns{running: map[string]time.Time{}, lastStable: time.Now()}
+		cc.services[e.Container.Service] = s
+	}
+
+	startedAt := e.Container.StartedAt
+	if startedAt.IsZero() {
+		startedAt = e.Time
+	}
+	switch e.Type {
+	case storages.ContainerStarted, storages.ContainerDiscovered:
+		s.running[e.Container.ID] = startedAt
+	case storages.ContainerDied:
+		started, ok := s.running[e.Container.ID]
+		if !ok {
+			return
+		}
+		if e.Time.Sub(started) >= cc.stableRun {
+			s.lastStable = e.Time
+		} else {
+			s.crashes = append(s.crashes, e.Time)
+		}
+		delete(s.running, e.Container.ID)
+	}
+}
+
+// Describe prometheus.Collector interface implementation
+func (cc *CrashLoopCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.restartsDesc
+	ch <- cc.crashloopingDesc
+	ch <- cc.sinceStableDesc
+}
+
+// Collect prometheus.Collector interface implementation
+func (cc *CrashLoopCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for name, s := range cc.services {
+		// crashes are ordered, so the ones out of window are in the beginning
+		i := 0
+		for i < len(s.crashes) && now.Sub(s.crashes[i]) > cc.window {
+			i++
+		}
+		s.crashes = s.crashes[i:]
+
+		for _, started := range s.running {
+			if now.Sub(started) >= cc.stableRun {
+				s.lastStable = now
+			}
+		}
+
+		crashlooping := 0.0
+		if len(s.crashes) >= cc.threshold {
+			crashlooping = 1
+		}
+		ch <- prometheus.MustNewConstMetric(cc.restartsDesc, prometheus.GaugeValue, float64(len(s.crashes)), name)
+		ch <- prometheus.MustNewConstMetric(cc.crashloopingDesc, prometheus.GaugeValue, crashlooping, name)
+		ch <- prometheus.MustNewConstMetric(cc.sinceStableDesc, prometheus.GaugeValue, now.Sub(s.lastStable).Seconds(), name)
+	}
+}
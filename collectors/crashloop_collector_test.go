@@ -0,0 +1,91 @@
+package collectors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gojuno/aleh/storages"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCrashLoopCollector(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+	started := func(id string, at time.Time) storages.LifecycleEvent {
+		return storages.LifecycleEvent{Type: storages.ContainerStarted, Time: at, Container: storages.Container{ID: id, Ecs: true, Service: "svc", StartedAt: at}}
+	}
+	died := func(id string, at time.Time) storages.LifecycleEvent {
+		return storages.LifecycleEvent{Type: storages.ContainerDied, Time: at, Container: storages.Container{ID: id, Ecs: true, Service: "svc"}}
+	}
+
+	tests := []struct {
+		name         string
+		events       []storages.LifecycleEvent
+		restarts     float64
+		crashlooping float64
+	}{
+		{
+			name:   "deploy of new replicas is not a restart",
+			events: []storages.LifecycleEvent{started("c1", ago(time.Minute)), started("c2", ago(time.Minute)), started("c3", ago(time.Minute))},
+		},
+		{
+			name: "replacing stable containers is not a restart",
+			events: []storages.LifecycleEvent{
+				started("c1", ago(time.Hour)), started("c2", ago(time.Hour)),
+				died("c1", ago(2*time.Minute)), started("c3", ago(2*time.Minute)),
+				died("c2", ago(time.Minute)), started("c4", ago(time.Minute)),
+			},
+		},
+		{
+			name: "short runs are restarts",
+			events: []storages.LifecycleEvent{
+				started("c1", ago(5*time.Minute)), died("c1", ago(4*time.Minute)),
+				started("c1", ago(4*time.Minute)), died("c1", ago(3*time.Minute)),
+				started("c1", ago(3*time.Minute)), died("c1", ago(2*time.Minute)),
+				started("c1", ago(2*time.Minute)),
+			},
+			restarts:     3,
+			crashlooping: 1,
+		},
+		{
+			name: "restarts out of window are forgotten",
+			events: []storages.LifecycleEvent{
+				started("c1", ago(time.Hour)), died("c1", ago(59*time.Minute)),
+				started("c1", ago(time.Minute)), died("c1", ago(30*time.Second)),
+			},
+			restarts: 1,
+		},
+		{
+			name:   "death of unknown container is ignored",
+			events: []storages.LifecycleEvent{died("c1", ago(time.Minute))},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := newCrashLoopCollector("test_", 10*time.Minute, 3, 5*time.Minute)
+			for _, e := range tt.events {
+				cc.handle(e)
+			}
+
+			r := prometheus.NewPedanticRegistry()
+			r.MustRegister(cc)
+			families, err := r.Gather()
+			if err != nil {
+				t.Fatalf("failed to gather: %v", err)
+			}
+			values := map[string]float64{}
+			for _, mf := range families {
+				for _, m := range mf.Metric {
+					values[mf.GetName()] = m.GetGauge().GetValue()
+				}
+			}
+			if got := values["test_service_restarts_window"]; got != tt.restarts {
+				t.Errorf("restarts %v, expected %v", got, tt.restarts)
+			}
+			if got := values["test_service_crashlooping"]; got != tt.crashlooping {
+				t.Errorf("crashlooping %v, expected %v", got, tt.crashlooping)
+			}
+		})
+	}
+}
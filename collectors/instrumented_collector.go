@@ -0,0 +1,34 @@
+package collectors
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InstrumentedCollector reports duration of the last collection of the wrapped collector.
+type InstrumentedCollector struct {
+	collector    prometheus.Collector
+	durationDesc *prometheus.Desc
+}
+
+// NewInstrumentedCollector wraps c, name identifies the collector in the duration metric.
+func NewInstrumentedCollector(metricPrefix, name string, c prometheus.Collector) *InstrumentedCollector {
+	return &InstrumentedCollector{
+		collector:    c,
+		durationDesc: prometheus.NewDesc(metricPrefix+"collector_duration_seconds", "Duration of the last collection of the collector", nil, prometheus.Labels{"collector": name}),
+	}
+}
+
+// Describe prometheus.Collector interface implementation
+func (ic *InstrumentedCollector) Describe(ch chan<- *prometheus.Desc) {
+	ic.collector.Describe(ch)
+	ch <- ic.durationDesc
+}
+
+// Collect prometheus.Collector interface implementation
+func (ic *InstrumentedCollector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	ic.collector.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(ic.durationDesc, prometheus.GaugeValue, time.Since(start).Seconds())
+}
@@ -0,0 +1,264 @@
+package collectors
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gojuno/aleh/storages"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// OverflowDrop replaces label values past the limit with an empty string.
+	OverflowDrop = "drop"
+	// OverflowBucket replaces label values past the limit with OverflowValue.
+	OverflowBucket = "bucket"
+	// OverflowValue is the label value used for bucketed values.
+	OverflowValue = "other"
+
+	// RevisionsJoined exports all revisions as one space separated revisions label.
+	RevisionsJoined = "joined"
+	// RevisionsLabels exports every configured component revision as its own revision_<component> label.
+	RevisionsLabels = "labels"
+	// RevisionsInfo exports revisions as a separate container_revision_info metric.
+	RevisionsInfo = "info"
+
+	// resolved values of containers not seen during this period are forgotten
+	labelsCacheTTL = 10 * time.Minute
+)
+
+// reserved label names are set by collectors themselves
+var reservedLabels = map[string]bool{
+	"service":      true,
+	"container":    true,
+	"container_id": true,
+	"revisions":    true,
+	"stat":         true,
+	"who":          true,
+	"agg":          true,
+	"file":         true,
+}
+
+// RevisionsConfig describes how container revisions are exported.
+type RevisionsConfig struct {
+	LabelPrefix string   `edn:"label_prefix" json:"label_prefix" yaml:"label_prefix"`
+	Mode        string   `edn:"mode" json:"mode" yaml:"mode"`
+	Components  []string `edn:"components" json:"components" yaml:"components"`
+}
+
+type resolvedLabels struct {
+	values []string
+	// counted values are the ones taking place under the limit
+	counted []bool
+	seen    time.Time
+}
+
+// ContainerLabels builds the label set shared by all per-container collectors.
+// Besides the fixed service, container, container_id and revision labels it exports
+// container labels from the configured allowlist, keeping the amount of distinct values
+// of every label used by recently seen containers under the limit.
+type ContainerLabels struct {
+	mu             sync.Mutex
+	revisionsMode  string
+	components     []string // revision components exported as labels
+	componentNames []string
+	keys           []string // container label keys
+	names          []string // prometheus label names in the same order as keys
+	limit          int
+	overflow       string
+	values         []map[string]int // amount of cached containers using every value of every label
+	cache          map[string]resolvedLabels
+	lastPurge      time.Time
+	overflows      *prometheus.CounterVec
+}
+
+// NewContainerLabels creates ContainerLabels for the given mapping of container label to prometheus label name.
+// limit is the maximum amount of distinct values for every exported label, zero means no limit.
+// overflow is one of OverflowDrop or OverflowBucket.
+func NewContainerLabels(metricPrefix string, mapping map[string]string, limit int, overflow string, revisions RevisionsConfig) *ContainerLabels {
+	if overflow == "" {
+		overflow = OverflowBucket
+	}
+	if overflow != OverflowDrop && overflow != OverflowBucket {
+		log.Printf("ERROR: unknown label overflow mode %q, using %q", overflow, OverflowBucket)
+		overflow = OverflowBucket
+	}
+
+	switch revisions.Mode {
+	case "":
+		revisions.Mode = RevisionsJoined
+	case RevisionsJoined, RevisionsLabels, RevisionsInfo:
+	default:
+		log.Printf("ERROR: unknown revisions mode %q, using %q", revisions.Mode, RevisionsJoined)
+		revisions.Mode = RevisionsJoined
+	}
+
+	keys := make([]string, 0, len(mapping))
+	for k := range mapping {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	cl := &ContainerLabels{
+		revisionsMode: revisions.Mode,
+		limit:         limit,
+		overflow:      overflow,
+		cache:         map[string]resolvedLabels{},
+		overflows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: metricPrefix + "label_values_overflow_total",
+			Help: "Amount of container label values dropped or bucketed because of the label values limit",
+		}, []string{"label"}),
+	}
+
+	used := map[string]bool{}
+	if revisions.Mode == RevisionsLabels {
+		for _, component := range revisions.Components {
+			name := "revision_" + sanitizeLabelName(component)
+			if used[name] {
+				continue
+			}
+			used[name] = true
+			cl.components = append(cl.components, component)
+			cl.componentNames = append(cl.componentNames, name)
+		}
+	}
+	for _, k := range keys {
+		name := sanitizeLabelName(mapping[k])
+		if name == "" {
+			name = sanitizeLabelName(k)
+		}
+		if reservedLabels[name] || strings.HasPrefix(name, "__") || strings.HasPrefix(name, "revision_") {
+			name = "label_" + strings.TrimLeft(name, "_")
+		}
+		if used[name] {
+			log.Printf("ERROR: container label %q maps to already used label name %q, skipping", k, name)
+			continue
+		}
+		used[name] = true
+		cl.keys = append(cl.keys, k)
+		cl.names = append(cl.names, name)
+		cl.values = append(cl.values, map[string]int{})
+	}
+	return cl
+}
+
+// sanitizeLabelName turns an arbitrary string into a valid prometheus label name.
+func sanitizeLabelName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' && i > 0) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// RevisionsMode returns the configured revisions mode.
+func (cl *ContainerLabels) RevisionsMode() string {
+	return cl.revisionsMode
+}
+
+// Names returns label names for per-container metrics, given names go first.
+func (cl *ContainerLabels) Names(first ...string) []string {
+	names := append([]string{}, first...)
+	names = append(names, "service", "container", "container_id")
+	if cl.revisionsMode == RevisionsJoined {
+		names = append(names, "revisions")
+	}
+	names = append(names, cl.componentNames...)
+	return append(names, cl.names...)
+}
+
+// Values returns label values of the container in the order of Names, given values go first.
+func (cl *ContainerLabels) Values(c storages.Container, first ...string) []string {
+	values := append([]string{}, first...)
+	values = append(values, c.Service, c.Container, c.ID)
+	if cl.revisionsMode == RevisionsJoined {
+		values = append(values, c.Revisions)
+	}
+	for _, component := range cl.components {
+		values = append(values, c.RevisionsMap[component])
+	}
+	return append(values, cl.resolve(c)...)
+}
+
+// EmptyValues returns label values for metrics not bound to a running container.
+func (cl *ContainerLabels) EmptyValues(service, container string, first ...string) []string {
+	values := append([]string{}, first...)
+	values = append(values, service, container, "")
+	if cl.revisionsMode == RevisionsJoined {
+		values = append(values, "")
+	}
+	return append(values, make([]string, len(cl.components)+len(cl.names))...)
+}
+
+func (cl *ContainerLabels) resolve(c storages.Container) []string {
+	if len(cl.keys) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if now.Sub(cl.lastPurge) > labelsCacheTTL {
+		for id, r := range cl.cache {
+			if now.Sub(r.seen) > labelsCacheTTL {
+				cl.release(r)
+				delete(cl.cache, id)
+			}
+		}
+		cl.lastPurge = now
+	}
+
+	if r, ok := cl.cache[c.ID]; ok {
+		r.seen = now
+		cl.cache[c.ID] = r
+		return r.values
+	}
+
+	r := resolvedLabels{values: make([]string, len(cl.keys)), counted: make([]bool, len(cl.keys)), seen: now}
+	for i, k := range cl.keys {
+		v := c.Labels[k]
+		if v == "" {
+			continue
+		}
+		if _, ok := cl.values[i][v]; ok || cl.limit <= 0 || len(cl.values[i]) < cl.limit {
+			cl.values[i][v]++
+			r.values[i], r.counted[i] = v, true
+			continue
+		}
+		cl.overflows.WithLabelValues(cl.names[i]).Inc()
+		if cl.overflow == OverflowBucket {
+			r.values[i] = OverflowValue
+		}
+	}
+	cl.cache[c.ID] = r
+	return r.values
+}
+
+// release frees values of the forgotten container, it should be called under lock.
+func (cl *ContainerLabels) release(r resolvedLabels) {
+	for i, v := range r.values {
+		if !r.counted[i] {
+			continue
+		}
+		if cl.values[i][v]--; cl.values[i][v] <= 0 {
+			delete(cl.values[i], v)
+		}
+	}
+}
+
+// Describe prometheus.Collector interface implementation
+func (cl *ContainerLabels) Describe(ch chan<- *prometheus.Desc) {
+	cl.overflows.Describe(ch)
+}
+
+// Collect prometheus.Collector interface implementation
+func (cl *ContainerLabels) Collect(ch chan<- prometheus.Metric) {
+	cl.overflows.Collect(ch)
+}
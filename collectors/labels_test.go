@@ -0,0 +1,102 @@
+package collectors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gojuno/aleh/storages"
+)
+
+// labelsStep resolves labels of the container after containers to forget are not seen for longer than cache TTL.
+type labelsStep struct {
+	container storages.Container
+	forget    []string
+	expected  string
+}
+
+func TestContainerLabelsLimit(t *testing.T) {
+	container := func(id, version string) storages.Container {
+		return storages.Container{ID: id, Service: "svc", Labels: map[string]string{"version": version}}
+	}
+	tests := []struct {
+		name     string
+		overflow string
+		steps    []labelsStep
+	}{
+		{
+			name:     "values past the limit are bucketed",
+			overflow: OverflowBucket,
+			steps: []labelsStep{
+				{container: container("c1", "v1"), expected: "v1"},
+				{container: container("c2", "v1"), expected: "v1"},
+				{container: container("c3", "v2"), expected: "v2"},
+				{container: container("c4", "v3"), expected: OverflowValue},
+			},
+		},
+		{
+			name:     "values past the limit are dropped",
+			overflow: OverflowDrop,
+			steps: []labelsStep{
+				{container: container("c1", "v1"), expected: "v1"},
+				{container: container("c2", "v2"), expected: "v2"},
+				{container: container("c3", "v3"), expected: ""},
+			},
+		},
+		{
+			name:     "values of forgotten containers are released",
+			overflow: OverflowBucket,
+			steps: []labelsStep{
+				{container: container("c1", "v1"), expected: "v1"},
+				{container: container("c2", "v2"), expected: "v2"},
+				{container: container("c3", "v3"), expected: OverflowValue},
+				{container: container("c4", "v4"), forget: []string{"c1", "c3"}, expected: "v4"},
+				{container: container("c5", "v5"), expected: OverflowValue},
+				{container: container("c6", "v2"), forget: []string{"c2"}, expected: "v2"},
+				{container: container("c7", "v7"), expected: OverflowValue},
+			},
+		},
+		{
+			name:     "value is kept while any container uses it",
+			overflow: OverflowBucket,
+			steps: []labelsStep{
+				{container: container("c1", "v1"), expected: "v1"},
+				{container: container("c2", "v1"), expected: "v1"},
+				{container: container("c3", "v2"), expected: "v2"},
+				{container: container("c4", "v3"), forget: []string{"c1"}, expected: OverflowValue},
+				{container: container("c5", "v1"), expected: "v1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewContainerLabels("test_", map[string]string{"version": "version"}, 2, tt.overflow, RevisionsConfig{})
+			for i, step := range tt.steps {
+				if len(step.forget) > 0 {
+					forget(cl, step.forget...)
+				}
+				values := cl.Values(step.container)
+				if got := values[len(values)-1]; got != step.expected {
+					t.Errorf("step %d: container %s got version %q, expected %q", i, step.container.ID, got, step.expected)
+				}
+			}
+		})
+	}
+}
+
+// forget makes containers unseen for longer than cache TTL and refreshes all others.
+func forget(cl *ContainerLabels, ids ...string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	past := time.Now().Add(-2 * labelsCacheTTL)
+	for id, r := range cl.cache {
+		r.seen = time.Now()
+		for _, forgotten := range ids {
+			if id == forgotten {
+				r.seen = past
+			}
+		}
+		cl.cache[id] = r
+	}
+	cl.lastPurge = past
+}
@@ -1,40 +1,31 @@
 package collectors
 
 import (
-	"sync"
+	"context"
 
 	"github.com/gojuno/aleh/storages"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// MemCollector reports to prometheus memory usage of known alive containers. Data is grabbed from cgroups pseudo memory stat file.
-type MemCollector struct {
-	storage *storages.InmemoryStorage
-	desc    *prometheus.Desc
+// MemCollector collects memory usage of containers. Data is grabbed from cgroups pseudo memory stat file.
+type MemCollector struct{}
+
+func NewMemCollector() MemCollector {
+	return MemCollector{}
 }
 
-func NewMemCollector(metricPrefix string, l *storages.InmemoryStorage) *MemCollector {
-	return &MemCollector{
-		storage: l,
-		desc:    prometheus.NewDesc(metricPrefix+"cgroup_memory_stats", "Container memory statistic", []string{"stat", "service", "container", "container_id", "revisions"}, nil),
-	}
+// Name ContainerCollector interface implementation
+func (MemCollector) Name() string {
+	return "mem"
 }
 
-// Describe prometheus.Collector interface implementation
-func (ms *MemCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- ms.desc
+// Metrics ContainerCollector interface implementation
+func (MemCollector) Metrics() []ContainerMetric {
+	return []ContainerMetric{{Name: "cgroup_memory_stats", Help: "Container memory statistic", Type: prometheus.GaugeValue, Labels: []string{"stat"}}}
 }
 
-// Collect prometheus.Collector interface implementation
-func (ms *MemCollector) Collect(ch chan<- prometheus.Metric) {
-	wg := sync.WaitGroup{}
-	for _, c := range ms.storage.AliveECSContainers() {
-		wg.Add(1)
-		go func(c storages.Container) {
-			defer wg.Done()
-			loadMetric(c, c.MemoryStatsPath, ms.desc, ch)
-		}(c)
-	}
-	wg.Wait()
+// CollectContainer ContainerCollector interface implementation
+func (MemCollector) CollectContainer(_ context.Context, c storages.Container, emit func(Sample)) error {
+	return loadMetric(c.MemoryStatsPath, "cgroup_memory_stats", emit)
 }
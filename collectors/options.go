@@ -0,0 +1,65 @@
+package collectors
+
+import (
+	"time"
+
+	"github.com/gojuno/aleh/storages"
+
+	"github.com/pkg/errors"
+)
+
+// ContainerCollectorOptions are settings of collectors reporting per-container stats.
+type ContainerCollectorOptions struct {
+	// Timeout limits a single collection, metrics of containers not collected in time are skipped.
+	// Zero means no limit.
+	Timeout time.Duration
+	// Filter selects containers to collect stats of.
+	Filter ServiceFilter
+	// Pool runs collection of containers, every container gets own goroutine when nil.
+	Pool *WorkerPool
+}
+
+// ServiceFilter selects containers by service name patterns, see RegexpPrefix for pattern syntax.
+type ServiceFilter struct {
+	include []namePattern
+	exclude []namePattern
+}
+
+// NewServiceFilter creates ServiceFilter accepting services matching any of include patterns,
+// or all services when include is empty, and none of exclude patterns.
+func NewServiceFilter(include, exclude []string) (ServiceFilter, error) {
+	f := ServiceFilter{}
+	for _, raw := range include {
+		p, err := newNamePattern(raw)
+		if err != nil {
+			return f, errors.Wrap(err, "services")
+		}
+		f.include = append(f.include, p)
+	}
+	for _, raw := range exclude {
+		p, err := newNamePattern(raw)
+		if err != nil {
+			return f, errors.Wrap(err, "exclude_services")
+		}
+		f.exclude = append(f.exclude, p)
+	}
+	return f, nil
+}
+
+// Match reports whether stats of the container should be collected.
+func (f ServiceFilter) Match(c storages.Container) bool {
+	for _, p := range f.exclude {
+		if p.match(c.Service) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, p := range f.include {
+		if p.match(c.Service) {
+			return true
+		}
+	}
+	return false
+}
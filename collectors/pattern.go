@@ -0,0 +1,44 @@
+package collectors
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RegexpPrefix marks service and container names in static services config which are regular expressions.
+// Names with glob meta characters *?[ are globs, other names are matched exactly.
+const RegexpPrefix = "re:"
+
+// namePattern matches service or container names.
+type namePattern struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+func newNamePattern(raw string) (namePattern, error) {
+	p := namePattern{raw: raw}
+	switch {
+	case strings.HasPrefix(raw, RegexpPrefix):
+		re, err := regexp.Compile("^(?:" + strings.TrimPrefix(raw, RegexpPrefix) + ")$")
+		if err != nil {
+			return p, errors.Wrapf(err, "invalid regexp %q", raw)
+		}
+		p.re = re
+	case strings.ContainsAny(raw, "*?["):
+		if _, err := path.Match(raw, ""); err != nil {
+			return p, errors.Wrapf(err, "invalid glob %q", raw)
+		}
+	}
+	return p, nil
+}
+
+func (p namePattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	ok, _ := path.Match(p.raw, name)
+	return ok
+}
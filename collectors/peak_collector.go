@@ -0,0 +1,276 @@
+package collectors
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gojuno/aleh/storages"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultPeakInterval is the default period of peak sampling.
+	DefaultPeakInterval = time.Second
+	// MaxPeakSamples is the amount of samples kept per container, window should fit in them
+	MaxPeakSamples = 600
+)
+
+var errNoCgroupFile = errors.New("no cgroup file found")
+
+// PeakOptions configure PeakCollector.
+type PeakOptions struct {
+	// Interval is the sampling period, DefaultPeakInterval when zero
+	Interval time.Duration
+	// Window is the period aggregates are computed over, zero means since the previous scrape
+	Window time.Duration
+	Filter ServiceFilter
+	// Pool runs sampling of containers, every container gets own goroutine when nil
+	Pool *WorkerPool
+}
+
+type peakSample struct {
+	at     time.Time
+	memory float64 // bytes
+	cpu    float64 // cores used since the previous sample, negative when unknown
+}
+
+type peakContainer struct {
+	container storages.Container
+	samples   []peakSample
+	// cpuUsage is cumulative nanoseconds read at cpuAt, valid when hasCPU is set
+	cpuUsage uint64
+	cpuAt    time.Time
+	hasCPU   bool
+}
+
+// PeakCollector samples memory usage and cpu usage of containers with high frequency and reports
+// max, min and avg over the window alongside the last sampled values, catching peaks between scrapes.
+type PeakCollector struct {
+	storage *storages.InmemoryStorage
+	labels  *ContainerLabels
+	opts    PeakOptions
+
+	memoryDesc       *prometheus.Desc
+	memoryWindowDesc *prometheus.Desc
+	cpuDesc          *prometheus.Desc
+	cpuWindowDesc    *prometheus.Desc
+
+	cgroupErrors prometheus.Counter
+
+	mu          sync.Mutex
+	containers  map[string]*peakContainer
+	lastCollect time.Time
+}
+
+// NewPeakCollector creates PeakCollector sampling containers until ctx is done.
+func NewPeakCollector(ctx context.Context, metricPrefix string, l *storages.InmemoryStorage, labels *ContainerLabels, opts PeakOptions) *PeakCollector {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultPeakInterval
+	}
+	pc := &PeakCollector{
+		storage:          l,
+		labels:           labels,
+		opts:             opts,
+		memoryDesc:       prometheus.NewDesc(metricPrefix+"container_memory_usage_bytes", "Last sampled container memory usage", labels.Names(), nil),
+		memoryWindowDesc: prometheus.NewDesc(metricPrefix+"container_memory_usage_window_bytes", "Max, min and avg of sampled container memory usage over the window", labels.Names("agg"), nil),
+		cpuDesc:          prometheus.NewDesc(metricPrefix+"container_cpu_usage_cores", "Container cpu usage between the last two samples", labels.Names(), nil),
+		cpuWindowDesc:    prometheus.NewDesc(metricPrefix+"container_cpu_usage_window_cores", "Max, min and avg of sampled container cpu usage over the window", labels.Names("agg"), nil),
+		cgroupErrors:     newCgroupErrors(metricPrefix, "peaks"),
+		containers:       map[string]*peakContainer{},
+		lastCollect:      time.Now(),
+	}
+	go pc.run(ctx)
+	return pc
+}
+
+func (pc *PeakCollector) run(ctx context.Context) {
+	ticker := time.NewTicker(pc.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pc.sample(ctx)
+		}
+	}
+}
+
+func (pc *PeakCollector) sample(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, pc.opts.Interval)
+	defer cancel()
+
+	alive := pc.storage.AliveECSContainers()
+	forEachContainer(ctx, pc.opts.Pool, alive, pc.opts.Filter, func(c storages.Container) func() {
+		now := time.Now()
+		memory, memErr := readMemoryUsage(c)
+		cpuUsage, cpuErr := readCPUUsage(c)
+		return func() {
+			for _, err := range []error{memErr, cpuErr} {
+				if _, ok := err.(CgroupReadError); ok {
+					pc.cgroupErrors.Inc()
+					log.Printf("ERROR: failed to sample container %s: %v", c.ID, err)
+				}
+			}
+			if memErr != nil && cpuErr != nil {
+				return
+			}
+			pc.mu.Lock()
+			defer pc.mu.Unlock()
+			p, ok := pc.containers[c.ID]
+			if !ok {
+				p = &peakContainer{}
+				pc.containers[c.ID] = p
+			}
+			s := peakSample{at: now, memory: -1, cpu: -1}
+			if memErr == nil {
+				s.memory = memory
+			}
+			if cpuErr == nil {
+				// the rate needs the previous successful read, failed reads in between are skipped
+				if p.hasCPU && cpuUsage >= p.cpuUsage {
+					if elapsed := now.Sub(p.cpuAt); elapsed > 0 {
+						s.cpu = float64(cpuUsage-p.cpuUsage) / float64(elapsed.Nanoseconds())
+					}
+				}
+				p.cpuUsage, p.cpuAt, p.hasCPU = cpuUsage, now, true
+			}
+			p.container = c
+			p.samples = append(p.samples, s)
+			if len(p.samples) > MaxPeakSamples {
+				p.samples = p.samples[len(p.samples)-MaxPeakSamples:]
+			}
+		}
+	})
+
+	pc.mu.Lock()
+	for id := range pc.containers {
+		if _, ok := alive[id]; !ok {
+			delete(pc.containers, id)
+		}
+	}
+	pc.mu.Unlock()
+}
+
+// readMemoryUsage reads memory.usage_in_bytes next to the container memory stat file.
+func readMemoryUsage(c storages.Container) (float64, error) {
+	v, err := readCgroupValue(c.MemoryStatsPath, "memory.usage_in_bytes")
+	return float64(v), err
+}
+
+// readCPUUsage reads cumulative cpu usage in nanoseconds next to the container cpu stat file.
+func readCPUUsage(c storages.Container) (uint64, error) {
+	return readCgroupValue(c.CPUStatsPath, "cpuacct.usage")
+}
+
+// readCgroupValue reads the first existing file with name next to the stat files,
+// errNoCgroupFile is returned when there is no such file.
+func readCgroupValue(statFiles []string, name string) (uint64, error) {
+	for _, statFile := range statFiles {
+		path := filepath.Join(filepath.Dir(statFile), name)
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, CgroupReadError{Path: path, Err: err}
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return 0, CgroupReadError{Path: path, Err: err}
+		}
+		return v, nil
+	}
+	return 0, errNoCgroupFile
+}
+
+// Describe prometheus.Collector interface implementation
+func (pc *PeakCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pc.memoryDesc
+	ch <- pc.memoryWindowDesc
+	ch <- pc.cpuDesc
+	ch <- pc.cpuWindowDesc
+	ch <- pc.cgroupErrors.Desc()
+}
+
+// Collect prometheus.Collector interface implementation
+func (pc *PeakCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	since := pc.lastCollect
+	if pc.opts.Window > 0 {
+		since = now.Add(-pc.opts.Window)
+	}
+	pc.lastCollect = now
+	ch <- pc.cgroupErrors
+
+	for _, p := range pc.containers {
+		var memory, cpu []float64
+		for _, s := range p.samples {
+			if s.at.Before(since) {
+				continue
+			}
+			if s.memory >= 0 {
+				memory = append(memory, s.memory)
+			}
+			if s.cpu >= 0 {
+				cpu = append(cpu, s.cpu)
+			}
+		}
+		if pc.opts.Window > 0 {
+			p.samples = trimSamples(p.samples, since)
+		} else {
+			p.samples = trimSamples(p.samples, now)
+		}
+
+		if last := len(p.samples) - 1; last >= 0 {
+			if s := p.samples[last]; s.memory >= 0 {
+				ch <- prometheus.MustNewConstMetric(pc.memoryDesc, prometheus.GaugeValue, s.memory, pc.labels.Values(p.container)...)
+			}
+			if s := p.samples[last]; s.cpu >= 0 {
+				ch <- prometheus.MustNewConstMetric(pc.cpuDesc, prometheus.GaugeValue, s.cpu, pc.labels.Values(p.container)...)
+			}
+		}
+		pc.collectWindow(pc.memoryWindowDesc, p.container, memory, ch)
+		pc.collectWindow(pc.cpuWindowDesc, p.container, cpu, ch)
+	}
+}
+
+// trimSamples drops samples before t keeping the last one for instantaneous values.
+func trimSamples(samples []peakSample, t time.Time) []peakSample {
+	i := 0
+	for i < len(samples)-1 && samples[i].at.Before(t) {
+		i++
+	}
+	return samples[i:]
+}
+
+func (pc *PeakCollector) collectWindow(desc *prometheus.Desc, c storages.Container, values []float64, ch chan<- prometheus.Metric) {
+	if len(values) == 0 {
+		return
+	}
+	max, min, sum := values[0], values[0], 0.0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+		if v < min {
+			min = v
+		}
+		sum += v
+	}
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, max, pc.labels.Values(c, "max")...)
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, min, pc.labels.Values(c, "min")...)
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, sum/float64(len(values)), pc.labels.Values(c, "avg")...)
+}
@@ -0,0 +1,90 @@
+package collectors
+
+import (
+	"context"
+
+	"github.com/gojuno/aleh/storages"
+)
+
+// DefaultPoolSize is the default amount of workers collecting per-container stats.
+const DefaultPoolSize = 16
+
+// WorkerPool runs per-container collection tasks of all collectors with bounded concurrency,
+// so a wedged cgroupfs or a slow daemon can't pile up goroutines.
+type WorkerPool struct {
+	tasks chan func()
+}
+
+// NewWorkerPool starts size workers, they stop when ctx is done.
+func NewWorkerPool(ctx context.Context, size int) *WorkerPool {
+	if size <= 0 {
+		size = DefaultPoolSize
+	}
+	p := &WorkerPool{tasks: make(chan func())}
+	for i := 0; i < size; i++ {
+		go p.work(ctx)
+	}
+	return p
+}
+
+func (p *WorkerPool) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case task := <-p.tasks:
+			task()
+		}
+	}
+}
+
+// submit waits for a free worker to run the task, it returns false when ctx is done first.
+func (p *WorkerPool) submit(ctx context.Context, task func()) bool {
+	select {
+	case p.tasks <- task:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// forEachContainer runs load for every container matching the filter in the pool, functions returned by load
+// are called sequentially in the calling goroutine. Containers not loaded until ctx is done are skipped,
+// it returns the amount of skipped and total matching containers.
+func forEachContainer(ctx context.Context, pool *WorkerPool, containers map[string]storages.Container, filter ServiceFilter, load func(storages.Container) func()) (skipped, total int) {
+	matched := make([]storages.Container, 0, len(containers))
+	for _, c := range containers {
+		if filter.Match(c) {
+			matched = append(matched, c)
+		}
+	}
+	total = len(matched)
+
+	results := make(chan func(), total)
+	go func() {
+		for _, c := range matched {
+			c := c
+			task := func() {
+				if ctx.Err() != nil {
+					return // skipped, the deadline passed while waiting for a worker
+				}
+				results <- load(c)
+			}
+			if pool == nil {
+				go task()
+			} else if !pool.submit(ctx, task) {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < total; i++ {
+		select {
+		case done := <-results:
+			done()
+		case <-ctx.Done():
+			return total - i, total
+		}
+	}
+	return 0, total
+}
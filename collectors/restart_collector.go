@@ -1,45 +1,143 @@
 package collectors
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"github.com/gojuno/aleh/storages"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// RestartCollector reports to prometheus service start's amount.
+// DefaultStateFlushInterval is the default period of saving restart counters to the state file.
+const DefaultStateFlushInterval = time.Minute
+
+// RestartCollector reports to prometheus service start's amount and restarts of alive containers.
+// Containers found running on aleh start are not counted as started unless they were
+// started while aleh was down according to the state file.
 type RestartCollector struct {
-	mu       sync.Mutex
-	desc     *prometheus.Desc
-	storage  *storages.InmemoryStorage
-	listener chan storages.Container
-	services map[string]float64
+	mu           sync.Mutex
+	metricPrefix string
+	desc         *prometheus.Desc
+	restartsDesc *prometheus.Desc
+	storage      *storages.InmemoryStorage
+	labels       *ContainerLabels
+	subscription *storages.Subscription
+	services     map[string]float64
+	statePath    string
+	// known containers are restored from the state file, nil when there is no state
+	// or the initial containers list is loaded
+	known map[string]knownContainer
 }
 
-func NewRestartCollector(metricPrefix string, l *storages.InmemoryStorage) *RestartCollector {
+// NewRestartCollector creates RestartCollector, counters are restored from and periodically saved to
+// the state file when statePath is not empty.
+func NewRestartCollector(ctx context.Context, metricPrefix string, l *storages.InmemoryStorage, labels *ContainerLabels, statePath string, flushInterval time.Duration) *RestartCollector {
 	rc := &RestartCollector{
-		services: map[string]float64{},
-		listener: make(chan storages.Container),
-		storage:  l,
-		desc:     prometheus.NewDesc(metricPrefix+"service_starts", "Amount of service starts", []string{"service"}, nil),
+		services:     map[string]float64{},
+		metricPrefix: metricPrefix,
+		statePath:    statePath,
+		storage:      l,
+		labels:       labels,
+		desc:         prometheus.NewDesc(metricPrefix+"service_starts", "Amount of service starts", []string{"service"}, nil),
+		restartsDesc: newRestartsDesc(metricPrefix, labels),
+	}
+	if statePath != "" {
+		rc.restoreState()
+		if flushInterval <= 0 {
+			flushInterval = DefaultStateFlushInterval
+		}
+		go rc.flushState(ctx, flushInterval)
 	}
-	l.AddContainerListener(rc.listener)
+	// containers discovered before subscription come in snapshot
+	rc.subscription = l.Subscribe("restarts", storages.SubscribeOptions{
+		Types:    []storages.EventType{storages.ContainerStarted, storages.ContainerDiscovered},
+		Snapshot: true,
+	})
 
 	go rc.countServices()
 	return rc
 }
 
 func (rc *RestartCollector) countServices() {
-	for c := range rc.listener {
-		rc.mu.Lock()
-		rc.services[c.Service] = rc.services[c.Service] + 1
-		rc.mu.Unlock()
+	events, loaded := rc.subscription.Events(), rc.storage.Loaded()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			rc.count(e)
+		case <-loaded:
+			loaded = nil
+			// events of the loaded containers are queued before the list is reported loaded
+			for n := len(events); n > 0; n-- {
+				e, ok := <-events
+				if !ok {
+					return
+				}
+				rc.count(e)
+			}
+			// containers discovered later, e.g. by resync, are not counted whether the state file exists or not
+			rc.mu.Lock()
+			rc.known = nil
+			rc.mu.Unlock()
+		}
+	}
+}
+
+func (rc *RestartCollector) count(e storages.LifecycleEvent) {
+	rc.mu.Lock()
+	rc.services[e.Container.Service] = rc.services[e.Container.Service] + rc.starts(e)
+	rc.mu.Unlock()
+}
+
+// starts returns amount of container starts represented by event.
+func (rc *RestartCollector) starts(e storages.LifecycleEvent) float64 {
+	if e.Type == storages.ContainerStarted && !e.Snapshot {
+		return 1
+	}
+	if rc.known == nil {
+		return 0
+	}
+
+	// container found on aleh start could be started or restarted while aleh was down
+	k, ok := rc.known[e.Container.ID]
+	if !ok {
+		return 1
+	}
+	delete(rc.known, e.Container.ID)
+	if e.Container.RestartCount > k.RestartCount {
+		return float64(e.Container.RestartCount - k.RestartCount)
 	}
+	return 0
+}
+
+func newRestartsDesc(metricPrefix string, labels *ContainerLabels) *prometheus.Desc {
+	return prometheus.NewDesc(metricPrefix+"container_restarts", "Amount of container restarts by docker restart policy", labels.Names(), nil)
+}
+
+// SetLabels changes labels of container restarts keeping service start counters.
+func (rc *RestartCollector) SetLabels(labels *ContainerLabels) {
+	rc.mu.Lock()
+	rc.labels = labels
+	rc.restartsDesc = newRestartsDesc(rc.metricPrefix, labels)
+	rc.mu.Unlock()
+}
+
+// Close stops counting starts and saves the state file.
+func (rc *RestartCollector) Close() error {
+	rc.subscription.Unsubscribe()
+	return rc.Save()
 }
 
 // Describe prometheus.Collector interface implementation
 func (rc *RestartCollector) Describe(ch chan<- *prometheus.Desc) {
+	rc.mu.Lock()
+	restartsDesc := rc.restartsDesc
+	rc.mu.Unlock()
 	ch <- rc.desc
+	ch <- restartsDesc
 }
 
 // Collect prometheus.Collector interface implementation
@@ -48,5 +146,10 @@ func (rc *RestartCollector) Collect(ch chan<- prometheus.Metric) {
 	for s, c := range rc.services {
 		ch <- prometheus.MustNewConstMetric(rc.desc, prometheus.CounterValue, c, s)
 	}
+	restartsDesc, labels := rc.restartsDesc, rc.labels
 	rc.mu.Unlock()
+
+	for _, c := range rc.storage.AliveECSContainers() {
+		ch <- prometheus.MustNewConstMetric(restartsDesc, prometheus.CounterValue, float64(c.RestartCount), labels.Values(c)...)
+	}
 }
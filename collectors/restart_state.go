@@ -0,0 +1,122 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// restartState is persisted between aleh restarts.
+type restartState struct {
+	Services map[string]float64 `json:"services"`
+	// Containers are alive containers at the moment of saving
+	Containers map[string]knownContainer `json:"containers"`
+}
+
+type knownContainer struct {
+	Service      string `json:"service"`
+	RestartCount int    `json:"restart_count"`
+}
+
+func readRestartState(path string) (*restartState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read state file %s", path)
+	}
+	st := &restartState{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal state file %s", path)
+	}
+	return st, nil
+}
+
+// writeRestartState replaces the state file atomically.
+func writeRestartState(path string, st restartState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal state")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary state file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "failed to write state to %s", tmp.Name())
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "failed to sync state file %s", tmp.Name())
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close state file %s", tmp.Name())
+	}
+	return errors.Wrapf(os.Rename(tmp.Name(), path), "failed to replace state file %s", path)
+}
+
+// restoreState loads counters and known containers saved by previous aleh run.
+func (rc *RestartCollector) restoreState() {
+	st, err := readRestartState(rc.statePath)
+	if os.IsNotExist(errors.Cause(err)) {
+		log.Printf("INFO: state file %s does not exist, starting from scratch", rc.statePath)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to restore state: %v", err)
+		return
+	}
+
+	rc.mu.Lock()
+	for s, c := range st.Services {
+		rc.services[s] = c
+	}
+	rc.known = st.Containers
+	if rc.known == nil {
+		rc.known = map[string]knownContainer{}
+	}
+	rc.mu.Unlock()
+	log.Printf("INFO: restored starts of %d services and %d containers from %s", len(st.Services), len(st.Containers), rc.statePath)
+}
+
+// Save writes counters and alive containers to the state file.
+func (rc *RestartCollector) Save() error {
+	if rc.statePath == "" {
+		return nil
+	}
+
+	st := restartState{Services: map[string]float64{}, Containers: map[string]knownContainer{}}
+	rc.mu.Lock()
+	for s, c := range rc.services {
+		st.Services[s] = c
+	}
+	rc.mu.Unlock()
+	for id, c := range rc.storage.AliveECSContainers() {
+		st.Containers[id] = knownContainer{Service: c.Service, RestartCount: c.RestartCount}
+	}
+	return writeRestartState(rc.statePath, st)
+}
+
+func (rc *RestartCollector) flushState(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := rc.Save(); err != nil {
+				log.Printf("ERROR: failed to save state: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package collectors
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SampledCollector collects metrics of the wrapped collector in background on its own interval,
+// Collect serves the last sample instantly so scrape latency does not depend on the wrapped collector.
+type SampledCollector struct {
+	collector    prometheus.Collector
+	ageDesc      *prometheus.Desc
+	durationDesc *prometheus.Desc
+
+	mu        sync.RWMutex
+	metrics   []prometheus.Metric
+	sampledAt time.Time
+	duration  time.Duration
+}
+
+// NewSampledCollector creates SampledCollector taking samples of c every interval until ctx is done,
+// name identifies the collector in sample age and duration metrics.
+func NewSampledCollector(ctx context.Context, metricPrefix, name string, c prometheus.Collector, interval time.Duration) *SampledCollector {
+	constLabels := prometheus.Labels{"collector": name}
+	sc := &SampledCollector{
+		collector:    c,
+		ageDesc:      prometheus.NewDesc(metricPrefix+"collector_sample_age_seconds", "Seconds since the last background sample of the collector", nil, constLabels),
+		durationDesc: prometheus.NewDesc(metricPrefix+"collector_sample_duration_seconds", "Duration of the last background sample of the collector", nil, constLabels),
+	}
+	go sc.run(ctx, interval)
+	return sc
+}
+
+func (sc *SampledCollector) run(ctx context.Context, interval time.Duration) {
+	sc.sample()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sc.sample()
+		}
+	}
+}
+
+func (sc *SampledCollector) sample() {
+	start := time.Now()
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		metrics := []prometheus.Metric{}
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+	sc.collector.Collect(ch)
+	close(ch)
+	metrics := <-done
+
+	sc.mu.Lock()
+	sc.metrics = metrics
+	sc.sampledAt = time.Now()
+	sc.duration = sc.sampledAt.Sub(start)
+	sc.mu.Unlock()
+}
+
+// Describe prometheus.Collector interface implementation
+func (sc *SampledCollector) Describe(ch chan<- *prometheus.Desc) {
+	sc.collector.Describe(ch)
+	ch <- sc.ageDesc
+	ch <- sc.durationDesc
+}
+
+// Collect prometheus.Collector interface implementation
+func (sc *SampledCollector) Collect(ch chan<- prometheus.Metric) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	if sc.sampledAt.IsZero() {
+		return
+	}
+	for _, m := range sc.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(sc.ageDesc, prometheus.GaugeValue, time.Since(sc.sampledAt).Seconds())
+	ch <- prometheus.MustNewConstMetric(sc.durationDesc, prometheus.GaugeValue, sc.duration.Seconds())
+}
@@ -1,15 +1,16 @@
 package collectors
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"context"
 	"log"
-	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gojuno/aleh/httpclient"
 
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -21,13 +22,16 @@ type dockerInfo struct {
 
 // DockerSpaceCollector reports to prometheus current docker disk space usage.
 type DockerSpaceCollector struct {
-	httpc http.Client
-	descs map[string]*prometheus.Desc
+	docker  *httpclient.Client
+	timeout time.Duration
+	descs   map[string]*prometheus.Desc
 }
 
-func NewDockerSpaceCollector(metricPrefix, socketPath string) *DockerSpaceCollector {
+// NewDockerSpaceCollector creates DockerSpaceCollector, timeout limits docker info request, zero means no limit.
+func NewDockerSpaceCollector(metricPrefix string, docker *httpclient.Client, timeout time.Duration) *DockerSpaceCollector {
 	return &DockerSpaceCollector{
-		httpc: httpclient.SocketClient(socketPath),
+		docker:  docker,
+		timeout: timeout,
 		descs: map[string]*prometheus.Desc{
 			"Data Space Available":         prometheus.NewDesc(metricPrefix+"docker_data_space_available", "Data Space Available", nil, nil),
 			"Metadata Space Available":     prometheus.NewDesc(metricPrefix+"docker_metadata_space_available", "Metadata Space Available", nil, nil),
@@ -49,21 +53,16 @@ func (s *DockerSpaceCollector) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect prometheus.Collector interface implementation
 func (s *DockerSpaceCollector) Collect(ch chan<- prometheus.Metric) {
-	dockerInfoPath := "http://localhost" + infoPath
-	resp, err := s.httpc.Get(dockerInfoPath)
-	if err != nil {
-		log.Printf("ERROR: failed to do http req to %s: %v", dockerInfoPath, err)
+	ctx := context.Background()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
 	}
-	defer resp.Body.Close()
 
-	bodyJson, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("ERROR: failed to read body from docker info request: %v", err)
-		return
-	}
 	di := dockerInfo{}
-	if err := json.Unmarshal(bodyJson, &di); err != nil {
-		log.Printf("ERROR: failed to unmarshall body `%s` from docker info request: %v", bodyJson, err)
+	if err := s.docker.GetJSON(ctx, infoPath, &di); err != nil {
+		log.Printf("ERROR: failed to get docker info: %v", err)
 		return
 	}
 
@@ -76,35 +75,39 @@ func (s *DockerSpaceCollector) Collect(ch chan<- prometheus.Metric) {
 		if !ok {
 			continue
 		}
-		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(humanReadableToBytes(info[len(info)-1])))
+		size, err := humanReadableToBytes(info[len(info)-1])
+		if err != nil {
+			log.Printf("ERROR: failed to parse docker info %s: %v", info[0], err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(size))
 	}
 }
 
 const kb = 1024
 
 var bytesMap = map[string]int64{
+	"B":  1,
 	"kB": kb,
+	"KB": kb,
 	"MB": kb * kb,
 	"GB": kb * kb * kb,
 	"TB": kb * kb * kb * kb,
 }
 
-var sizeRegexp = regexp.MustCompile(`([\d.]+)([kMGT]B)$`)
+// docker reports sizes like "10.74GB" or "1.5 GB"
+var sizeRegexp = regexp.MustCompile(`^([\d.]+)\s*([kKMGT]?B)$`)
 
-func humanReadableToBytes(size string) int64 {
-	m := sizeRegexp.FindStringSubmatch(size)
+func humanReadableToBytes(size string) (int64, error) {
+	m := sizeRegexp.FindStringSubmatch(strings.TrimSpace(size))
 	if len(m) != 3 {
-		log.Printf("ERROR: failed to parse size %s", size)
+		return 0, errors.Errorf("unknown size format %q", size)
 	}
 	number, suffix := m[1], m[2]
 
 	rawSize, err := strconv.ParseFloat(number, 64)
 	if err != nil {
-		log.Printf("ERROR: failed to convert %s to int from raw %s: %v", number, size, err)
-	}
-	multiplier := int64(1000)
-	if m := bytesMap[suffix]; m != 0 {
-		multiplier = m
+		return 0, errors.Wrapf(err, "failed to parse size %q", size)
 	}
-	return int64(rawSize * float64(multiplier))
+	return int64(rawSize * float64(bytesMap[suffix])), nil
 }
@@ -0,0 +1,313 @@
+package collectors
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gojuno/aleh/storages"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// TextfileOptions configure TextfileCollector, at least one of Directory and MountPath should be set.
+type TextfileOptions struct {
+	ContainerCollectorOptions
+	// Directory contains per-container directories named by container ID with *.prom files
+	Directory string
+	// MountPath is a directory inside containers, *.prom files are read from the host source of the mount containing it
+	MountPath string
+	// HostRoot is prepended to mount sources when aleh sees the host filesystem under some directory
+	HostRoot string
+	// ReservedNames are names of metrics reported by other collectors, text file families with these names are dropped
+	ReservedNames map[string]bool
+}
+
+// TextfileCollector reports to prometheus samples from *.prom files written by containers
+// in the text exposition format, container labels are added to every sample.
+// Metric names starting with the aleh metric prefix or reserved by other collectors are rejected.
+type TextfileCollector struct {
+	storage      *storages.InmemoryStorage
+	labels       *ContainerLabels
+	opts         TextfileOptions
+	metricPrefix string
+	mtimeDesc    *prometheus.Desc
+	errors       prometheus.Counter
+}
+
+func NewTextfileCollector(metricPrefix string, l *storages.InmemoryStorage, labels *ContainerLabels, opts TextfileOptions) *TextfileCollector {
+	return &TextfileCollector{
+		storage:      l,
+		labels:       labels,
+		opts:         opts,
+		metricPrefix: metricPrefix,
+		mtimeDesc:    prometheus.NewDesc(metricPrefix+"textfile_mtime_seconds", "Modification time of the container metrics text file", labels.Names("file"), nil),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: metricPrefix + "textfile_errors_total",
+			Help: "Amount of errors reading, parsing or exporting container metrics text files",
+		}),
+	}
+}
+
+type textfile struct {
+	name  string
+	mtime time.Time
+}
+
+type containerFamilies struct {
+	container storages.Container
+	families  []*dto.MetricFamily
+}
+
+// Describe prometheus.Collector interface implementation, samples from text files are not described.
+func (tc *TextfileCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tc.mtimeDesc
+	ch <- tc.errors.Desc()
+}
+
+// Collect prometheus.Collector interface implementation
+func (tc *TextfileCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	if tc.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tc.opts.Timeout)
+		defer cancel()
+	}
+
+	loaded := []containerFamilies{}
+	skipped, total := forEachContainer(ctx, tc.opts.Pool, tc.storage.AliveECSContainers(), tc.opts.Filter, func(c storages.Container) func() {
+		families, files, err := tc.read(c)
+		return func() {
+			if err != nil {
+				tc.errors.Inc()
+				log.Printf("ERROR: failed to read metrics text files of container %s: %v", c.ID, err)
+			}
+			for _, f := range files {
+				ch <- prometheus.MustNewConstMetric(tc.mtimeDesc, prometheus.GaugeValue, float64(f.mtime.Unix()), tc.labels.Values(c, f.name)...)
+			}
+			loaded = append(loaded, containerFamilies{container: c, families: families})
+		}
+	})
+	if skipped > 0 {
+		log.Printf("ERROR: textfile collector timed out after %s, skipped %d of %d containers", tc.opts.Timeout, skipped, total)
+		tc.errors.Add(float64(skipped))
+	}
+	tc.export(loaded, ch)
+	tc.errors.Collect(ch)
+}
+
+// dirs returns directories with metrics text files of the container.
+func (tc *TextfileCollector) dirs(c storages.Container) []string {
+	dirs := []string{}
+	if tc.opts.Directory != "" {
+		dirs = append(dirs, filepath.Join(tc.opts.Directory, c.ID))
+	}
+	if tc.opts.MountPath != "" {
+		if source, ok := mountSource(c.Mounts, tc.opts.MountPath); ok {
+			dirs = append(dirs, filepath.Join(tc.opts.HostRoot, source))
+		}
+	}
+	return dirs
+}
+
+// mountSource returns the host path of the path inside container.
+func mountSource(mounts []storages.Mount, path string) (string, bool) {
+	path = filepath.Clean(path)
+	best := -1
+	for i, m := range mounts {
+		dst := filepath.Clean(m.Destination)
+		if path != dst && !strings.HasPrefix(path, strings.TrimSuffix(dst, "/")+"/") {
+			continue
+		}
+		if best < 0 || len(dst) > len(filepath.Clean(mounts[best].Destination)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", false
+	}
+	rel := strings.TrimPrefix(path, filepath.Clean(mounts[best].Destination))
+	return filepath.Join(mounts[best].Source, rel), true
+}
+
+// read parses all metrics text files of the container, it returns the last error and everything parsed.
+func (tc *TextfileCollector) read(c storages.Container) ([]*dto.MetricFamily, []textfile, error) {
+	var lastErr error
+	families := []*dto.MetricFamily{}
+	files := []textfile{}
+	for _, dir := range tc.dirs(c) {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.prom"))
+		if err != nil {
+			lastErr = errors.Wrapf(err, "failed to list %s", dir)
+			continue
+		}
+		for _, path := range paths {
+			fs, mtime, err := readTextfile(path)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			files = append(files, textfile{name: filepath.Base(path), mtime: mtime})
+			families = append(families, fs...)
+		}
+	}
+	return families, files, lastErr
+}
+
+func readTextfile(path string) ([]*dto.MetricFamily, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, errors.Wrapf(err, "failed to stat %s", path)
+	}
+	var parser expfmt.TextParser
+	parsed, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrapf(err, "failed to parse %s", path)
+	}
+	families := make([]*dto.MetricFamily, 0, len(parsed))
+	for _, mf := range parsed {
+		families = append(families, mf)
+	}
+	return families, fi.ModTime(), nil
+}
+
+type exportSample struct {
+	container storages.Container
+	metric    *dto.Metric
+}
+
+type exportFamily struct {
+	help       string
+	typ        dto.MetricType
+	labelNames map[string]bool
+	samples    []exportSample
+}
+
+// export merges families of all containers, every family gets the union of its label names
+// so that samples of different containers are consistent.
+func (tc *TextfileCollector) export(loaded []containerFamilies, ch chan<- prometheus.Metric) {
+	reserved := map[string]bool{}
+	for _, name := range tc.labels.Names() {
+		reserved[name] = true
+	}
+
+	// the first container defines type and help of a family
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].container.ID < loaded[j].container.ID })
+	families := map[string]*exportFamily{}
+	for _, cf := range loaded {
+		for _, mf := range cf.families {
+			name := mf.GetName()
+			if strings.HasPrefix(name, tc.metricPrefix) {
+				tc.errors.Inc()
+				log.Printf("ERROR: metric %s of container %s uses reserved prefix %s", name, cf.container.ID, tc.metricPrefix)
+				continue
+			}
+			if tc.opts.ReservedNames[name] {
+				tc.errors.Inc()
+				log.Printf("ERROR: metric %s of container %s is already reported by another collector", name, cf.container.ID)
+				continue
+			}
+			ef, ok := families[name]
+			if !ok {
+				ef = &exportFamily{help: mf.GetHelp(), typ: mf.GetType(), labelNames: map[string]bool{}}
+				families[name] = ef
+			}
+			if ef.typ != mf.GetType() {
+				tc.errors.Inc()
+				log.Printf("ERROR: metric %s of container %s has type %s, expected %s", name, cf.container.ID, mf.GetType(), ef.typ)
+				continue
+			}
+			for _, m := range mf.Metric {
+				for _, lp := range m.Label {
+					ef.labelNames[exportedLabelName(lp.GetName(), reserved)] = true
+				}
+				ef.samples = append(ef.samples, exportSample{container: cf.container, metric: m})
+			}
+		}
+	}
+
+	for name, ef := range families {
+		labelNames := make([]string, 0, len(ef.labelNames))
+		for l := range ef.labelNames {
+			labelNames = append(labelNames, l)
+		}
+		sort.Strings(labelNames)
+		desc := prometheus.NewDesc(name, ef.help, tc.labels.Names(labelNames...), nil)
+
+		seen := map[string]bool{}
+		for _, s := range ef.samples {
+			values := map[string]string{}
+			for _, lp := range s.metric.Label {
+				values[exportedLabelName(lp.GetName(), reserved)] = lp.GetValue()
+			}
+			labelValues := make([]string, len(labelNames))
+			for i, l := range labelNames {
+				labelValues[i] = values[l]
+			}
+			key := s.container.ID + "\xff" + strings.Join(labelValues, "\xff")
+			if seen[key] {
+				tc.errors.Inc()
+				log.Printf("ERROR: duplicate sample of metric %s in container %s", name, s.container.ID)
+				continue
+			}
+			seen[key] = true
+
+			m, err := constMetric(desc, ef.typ, s.metric, tc.labels.Values(s.container, labelValues...))
+			if err != nil {
+				tc.errors.Inc()
+				log.Printf("ERROR: failed to export metric %s of container %s: %v", name, s.container.ID, err)
+				continue
+			}
+			ch <- m
+		}
+	}
+}
+
+// exportedLabelName renames text file labels clashing with container labels.
+func exportedLabelName(name string, reserved map[string]bool) string {
+	if reserved[name] {
+		return "exported_" + name
+	}
+	return name
+}
+
+func constMetric(desc *prometheus.Desc, typ dto.MetricType, m *dto.Metric, labelValues []string) (prometheus.Metric, error) {
+	switch typ {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, m.GetCounter().GetValue(), labelValues...)
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), labelValues...)
+	case dto.MetricType_UNTYPED:
+		return prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), labelValues...)
+	case dto.MetricType_SUMMARY:
+		quantiles := map[float64]float64{}
+		for _, q := range m.GetSummary().GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		return prometheus.NewConstSummary(desc, m.GetSummary().GetSampleCount(), m.GetSummary().GetSampleSum(), quantiles, labelValues...)
+	case dto.MetricType_HISTOGRAM:
+		buckets := map[float64]uint64{}
+		for _, b := range m.GetHistogram().GetBucket() {
+			if math.IsInf(b.GetUpperBound(), 1) {
+				continue // implied by the sample count
+			}
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+		return prometheus.NewConstHistogram(desc, m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum(), buckets, labelValues...)
+	}
+	return nil, errors.Errorf("unsupported metric type %s", typ)
+}
@@ -0,0 +1,569 @@
+package aleh
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gojuno/aleh/collectors"
+	"github.com/gojuno/aleh/httpclient"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"olympos.io/encoding/edn"
+)
+
+const (
+	// EnvPrefix is the prefix of environment variables overriding config fields.
+	// ALEH_ENDPOINT overrides endpoint, nested fields are joined with underscore like ALEH_CRASHLOOP_WINDOW,
+	// maps and lists are written as JSON.
+	EnvPrefix = "ALEH_"
+
+	DefaultMetricPrefix       = "aleh_"
+	DefaultDockerDaemonSocket = "/var/run/docker.sock"
+	DefaultEndpoint           = "0.0.0.0:1234"
+	DefaultScrapeTimeout      = Duration(10 * time.Second)
+)
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	dockerAPIVersionRe = regexp.MustCompile(`^1\.[0-9]+$`)
+)
+
+type Config struct {
+	// DockerDaemonSocket is the docker unix socket used when neither docker_host nor DOCKER_HOST env var is set
+	DockerDaemonSocket string `edn:"docker_daemon_socket" json:"docker_daemon_socket" yaml:"docker_daemon_socket"`
+	// DockerHost is the docker daemon address like unix:///var/run/docker.sock or tcp://host:2376,
+	// DOCKER_HOST env var is used when empty
+	DockerHost string `edn:"docker_host" json:"docker_host" yaml:"docker_host"`
+	// DockerTLS enables TLS without verifying the daemon certificate, non-empty DOCKER_TLS env var enables it too
+	DockerTLS bool `edn:"docker_tls" json:"docker_tls" yaml:"docker_tls"`
+	// DockerTLSVerify enables TLS verifying the daemon certificate, non-empty DOCKER_TLS_VERIFY env var enables it too
+	DockerTLSVerify bool `edn:"docker_tls_verify" json:"docker_tls_verify" yaml:"docker_tls_verify"`
+	// DockerCertPath is the directory with ca.pem, cert.pem and key.pem used with TLS.
+	// DOCKER_CERT_PATH env var is used when empty, ~/.docker when TLS is enabled
+	DockerCertPath string `edn:"docker_cert_path" json:"docker_cert_path" yaml:"docker_cert_path"`
+	// DockerAPIVersion pins docker API version like 1.24, DOCKER_API_VERSION env var is used when empty.
+	// The version is negotiated with the daemon when neither is set
+	DockerAPIVersion string `edn:"docker_api_version" json:"docker_api_version" yaml:"docker_api_version"`
+
+	Endpoint     string                                         `edn:"endpoint" json:"endpoint" yaml:"endpoint"`
+	MetricPrefix string                                         `edn:"metric_prefix" json:"metric_prefix" yaml:"metric_prefix"`
+	Services     map[string]map[string]collectors.ContainerInfo `edn:"services" json:"services" yaml:"services"`
+	// Labels maps container labels to prometheus label names exported by per-container collectors
+	Labels           map[string]string          `edn:"labels" json:"labels" yaml:"labels"`
+	LabelValuesLimit int                        `edn:"label_values_limit" json:"label_values_limit" yaml:"label_values_limit"`
+	LabelOverflow    string                     `edn:"label_overflow" json:"label_overflow" yaml:"label_overflow"`
+	Revisions        collectors.RevisionsConfig `edn:"revisions" json:"revisions" yaml:"revisions"`
+	// ResyncInterval is the period of full containers list reconciliation with docker, disabled when empty
+	ResyncInterval Duration `edn:"resync_interval" json:"resync_interval" yaml:"resync_interval"`
+	// EventsReconnectMaxDelay limits the backoff between docker events stream reconnects
+	EventsReconnectMaxDelay Duration `edn:"events_reconnect_max_delay" json:"events_reconnect_max_delay" yaml:"events_reconnect_max_delay"`
+	// EventWorkers is the amount of concurrent docker event handlers
+	EventWorkers int `edn:"event_workers" json:"event_workers" yaml:"event_workers"`
+	// InspectConcurrency limits concurrent docker container inspect requests
+	InspectConcurrency int `edn:"inspect_concurrency" json:"inspect_concurrency" yaml:"inspect_concurrency"`
+	// ForgetGracePeriod is the delay before destroyed container is forgotten
+	ForgetGracePeriod Duration `edn:"forget_grace_period" json:"forget_grace_period" yaml:"forget_grace_period"`
+	// StateFile keeps service start counters between aleh restarts, disabled when empty
+	StateFile          string   `edn:"state_file" json:"state_file" yaml:"state_file"`
+	StateFlushInterval Duration `edn:"state_flush_interval" json:"state_flush_interval" yaml:"state_flush_interval"`
+
+	CrashLoop CrashLoopConfig `edn:"crashloop" json:"crashloop" yaml:"crashloop"`
+
+	Collectors CollectorsConfig `edn:"collectors" json:"collectors" yaml:"collectors"`
+	// CollectionWorkers limits concurrent per-container collection of all collectors
+	CollectionWorkers int `edn:"collection_workers" json:"collection_workers" yaml:"collection_workers"`
+	// ScrapeTimeout limits collection of per-container collectors without own timeout
+	ScrapeTimeout Duration `edn:"scrape_timeout" json:"scrape_timeout" yaml:"scrape_timeout"`
+}
+
+// CrashLoopConfig configures detection of services restarting repeatedly.
+type CrashLoopConfig struct {
+	// Window is the period restarts are counted within
+	Window Duration `edn:"window" json:"window" yaml:"window"`
+	// Threshold is the amount of restarts within the window to consider service crashlooping
+	Threshold int `edn:"threshold" json:"threshold" yaml:"threshold"`
+	// StableRun is the minimal duration of a successful container run
+	StableRun Duration `edn:"stable_run" json:"stable_run" yaml:"stable_run"`
+}
+
+// CollectorsConfig lists collectors and their settings.
+// The cpu collector is disabled by default, all other collectors are enabled.
+type CollectorsConfig struct {
+	CPU         CollectorConfig `edn:"cpu" json:"cpu" yaml:"cpu"`
+	Mem         CollectorConfig `edn:"mem" json:"mem" yaml:"mem"`
+	Alive       CollectorConfig `edn:"alive" json:"alive" yaml:"alive"`
+	Restarts    CollectorConfig `edn:"restarts" json:"restarts" yaml:"restarts"`
+	CrashLoop   CollectorConfig `edn:"crashloop" json:"crashloop" yaml:"crashloop"`
+	DockerSpace CollectorConfig `edn:"docker_space" json:"docker_space" yaml:"docker_space"`
+	Textfile    TextfileConfig  `edn:"textfile" json:"textfile" yaml:"textfile"`
+	Peaks       PeaksConfig     `edn:"peaks" json:"peaks" yaml:"peaks"`
+	// Custom configures per-container collectors passed in Options by name
+	Custom map[string]CollectorConfig `edn:"custom" json:"custom" yaml:"custom"`
+}
+
+// CollectorConfig enables a collector and sets its options.
+// Timeout and sample interval are supported by per-container and docker_space collectors,
+// service filters by per-container collectors.
+type CollectorConfig struct {
+	Enabled *bool `edn:"enabled" json:"enabled" yaml:"enabled"`
+	// Timeout limits a single collection, scrape_timeout is used for per-container collectors when empty
+	Timeout Duration `edn:"timeout" json:"timeout" yaml:"timeout"`
+	// SampleInterval enables background sampling, scrapes are served from the last sample
+	SampleInterval Duration `edn:"sample_interval" json:"sample_interval" yaml:"sample_interval"`
+	// Services limits collection to services matching any of the patterns, all services when empty
+	Services []string `edn:"services" json:"services" yaml:"services"`
+	// ExcludeServices skips services matching any of the patterns
+	ExcludeServices []string `edn:"exclude_services" json:"exclude_services" yaml:"exclude_services"`
+}
+
+// TextfileConfig configures the collector of *.prom files written by containers, it is disabled by default.
+type TextfileConfig struct {
+	Enabled         *bool    `edn:"enabled" json:"enabled" yaml:"enabled"`
+	Timeout         Duration `edn:"timeout" json:"timeout" yaml:"timeout"`
+	SampleInterval  Duration `edn:"sample_interval" json:"sample_interval" yaml:"sample_interval"`
+	Services        []string `edn:"services" json:"services" yaml:"services"`
+	ExcludeServices []string `edn:"exclude_services" json:"exclude_services" yaml:"exclude_services"`
+	// Directory contains per-container directories named by container ID with *.prom files
+	Directory string `edn:"directory" json:"directory" yaml:"directory"`
+	// MountPath is a directory inside containers, files are read from the host source of the mount containing it
+	MountPath string `edn:"mount_path" json:"mount_path" yaml:"mount_path"`
+	// HostRoot is prepended to mount sources when aleh sees the host filesystem under some directory
+	HostRoot string `edn:"host_root" json:"host_root" yaml:"host_root"`
+}
+
+// PeaksConfig configures high-frequency sampling of container memory and cpu usage, it is disabled by default.
+type PeaksConfig struct {
+	Enabled *bool `edn:"enabled" json:"enabled" yaml:"enabled"`
+	// Interval is the sampling period, 1s when empty
+	Interval Duration `edn:"interval" json:"interval" yaml:"interval"`
+	// Window is the period max, min and avg are computed over, since the previous scrape when empty
+	Window          Duration `edn:"window" json:"window" yaml:"window"`
+	Services        []string `edn:"services" json:"services" yaml:"services"`
+	ExcludeServices []string `edn:"exclude_services" json:"exclude_services" yaml:"exclude_services"`
+}
+
+func (c TextfileConfig) collectorConfig() CollectorConfig {
+	return CollectorConfig{
+		Enabled:         c.Enabled,
+		Timeout:         c.Timeout,
+		SampleInterval:  c.SampleInterval,
+		Services:        c.Services,
+		ExcludeServices: c.ExcludeServices,
+	}
+}
+
+// IsEnabled reports whether the collector is enabled, def is used when enabled is not set.
+func (c CollectorConfig) IsEnabled(def bool) bool {
+	if c.Enabled == nil {
+		return def
+	}
+	return *c.Enabled
+}
+
+// ReadConfig reads the config file, the format is chosen by extension:
+// .yaml and .yml are YAML, .json is JSON and everything else is EDN.
+// Unknown keys are rejected in all formats.
+// Environment overrides and defaults are applied before validation.
+func ReadConfig(path string) (Config, error) {
+	c := Config{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, errors.Wrapf(err, "failed to read config file %s", path)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &c)
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		err = d.Decode(&c)
+	default:
+		if err = checkEDNKeys(data, reflect.TypeOf(c)); err == nil {
+			err = edn.Unmarshal(data, &c)
+		}
+	}
+	if err != nil {
+		return c, errors.Wrapf(err, "failed to parse config file %s", path)
+	}
+
+	if err := applyEnv(reflect.ValueOf(&c).Elem(), EnvPrefix, os.LookupEnv); err != nil {
+		return c, err
+	}
+	c.setDefaults()
+	return c, c.Validate()
+}
+
+// checkEDNKeys returns error for keys of EDN maps not matching fields of t,
+// edn.Unmarshal silently skips them like typos in optional settings.
+func checkEDNKeys(data []byte, t reflect.Type) error {
+	var v interface{}
+	if err := edn.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return checkKeys(v, t, "")
+}
+
+func checkKeys(v interface{}, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		for k, fv := range m {
+			name := fmt.Sprint(k)
+			if kw, ok := k.(edn.Keyword); ok {
+				name = string(kw)
+			}
+			f, ok := ednField(t, name)
+			if !ok {
+				return errors.Errorf("unknown key %s", path+name)
+			}
+			if err := checkKeys(fv, f.Type, path+name+"."); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		for k, mv := range m {
+			if err := checkKeys(mv, t.Elem(), path+fmt.Sprint(k)+"."); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ednField finds field of t decoded from EDN key name, keys are matched case-insensitively like edn package does.
+func ednField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("edn"), ",")[0]
+		if tag == "-" || f.PkgPath != "" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if strings.EqualFold(tag, name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func (c *Config) setDefaults() {
+	if c.MetricPrefix == "" {
+		c.MetricPrefix = DefaultMetricPrefix
+	}
+	if c.DockerDaemonSocket == "" {
+		c.DockerDaemonSocket = DefaultDockerDaemonSocket
+	}
+	c.setDockerDefaults()
+	if c.Endpoint == "" {
+		c.Endpoint = DefaultEndpoint
+	}
+	if c.CollectionWorkers == 0 {
+		c.CollectionWorkers = collectors.DefaultPoolSize
+	}
+	if c.ScrapeTimeout == 0 {
+		c.ScrapeTimeout = DefaultScrapeTimeout
+	}
+	if c.Collectors.CPU.Enabled == nil && os.Getenv("CPU_STATS") == "true" {
+		log.Printf("WARN: CPU_STATS env var is deprecated, use collectors cpu enabled config instead")
+		enabled := true
+		c.Collectors.CPU.Enabled = &enabled
+	}
+}
+
+// withReloadable returns c with settings applied on reload taken from n.
+func (c Config) withReloadable(n Config) Config {
+	c.Services = n.Services
+	c.Labels = n.Labels
+	c.LabelValuesLimit = n.LabelValuesLimit
+	c.LabelOverflow = n.LabelOverflow
+	// the revision label prefix is used by the storage
+	c.Revisions.Mode = n.Revisions.Mode
+	c.Revisions.Components = n.Revisions.Components
+	c.CrashLoop = n.CrashLoop
+	c.Collectors = n.Collectors
+	c.CollectionWorkers = n.CollectionWorkers
+	c.ScrapeTimeout = n.ScrapeTimeout
+	return c
+}
+
+// setDockerDefaults fills docker connection settings from DOCKER_* env vars like docker cli does.
+func (c *Config) setDockerDefaults() {
+	if c.DockerHost == "" {
+		c.DockerHost = os.Getenv("DOCKER_HOST")
+	}
+	if c.DockerHost == "" {
+		c.DockerHost = "unix://" + c.DockerDaemonSocket
+	}
+	if os.Getenv("DOCKER_TLS") != "" {
+		c.DockerTLS = true
+	}
+	if os.Getenv("DOCKER_TLS_VERIFY") != "" {
+		c.DockerTLSVerify = true
+	}
+	if c.DockerCertPath == "" {
+		c.DockerCertPath = os.Getenv("DOCKER_CERT_PATH")
+	}
+	if c.DockerCertPath == "" && (c.DockerTLS || c.DockerTLSVerify) {
+		if home, err := os.UserHomeDir(); err == nil {
+			c.DockerCertPath = filepath.Join(home, ".docker")
+		}
+	}
+	if c.DockerAPIVersion == "" {
+		c.DockerAPIVersion = os.Getenv("DOCKER_API_VERSION")
+	}
+}
+
+// applyEnv overrides fields of the struct v with environment variables named after their edn tags.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("edn")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + strings.ToUpper(strings.Replace(tag, "-", "_", -1))
+		f := v.Field(i)
+		if _, ok := f.Addr().Interface().(encoding.TextUnmarshaler); !ok && f.Kind() == reflect.Struct {
+			if err := applyEnv(f, name+"_", lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(f, raw); err != nil {
+			return errors.Wrapf(err, "invalid value of %s", name)
+		}
+	}
+	return nil
+}
+
+func setEnvValue(v reflect.Value, raw string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(raw))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		return json.Unmarshal([]byte(raw), v.Addr().Interface())
+	}
+	return nil
+}
+
+// FieldError describes an invalid config field.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError lists all invalid config fields.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks the config, all invalid fields are reported as ValidationError.
+func (c Config) Validate() error {
+	var errs ValidationError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.DockerDaemonSocket == "" {
+		add("docker_daemon_socket", "must not be empty")
+	}
+	if _, _, err := httpclient.ParseHost(c.DockerHost); err != nil {
+		add("docker_host", "%v", err)
+	}
+	if c.DockerAPIVersion != "" && !dockerAPIVersionRe.MatchString(c.DockerAPIVersion) {
+		add("docker_api_version", "must be like 1.24")
+	}
+	if host, port, err := net.SplitHostPort(c.Endpoint); err != nil {
+		add("endpoint", "must be host:port: %v", err)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		add("endpoint", "invalid port %q of host %q", port, host)
+	}
+	if !metricNameRe.MatchString(c.MetricPrefix) {
+		add("metric_prefix", "%q is not a valid metric name prefix", c.MetricPrefix)
+	}
+
+	if err := collectors.ValidateServices(c.Services); err != nil {
+		add("services", "%v", err)
+	}
+	for svc, containers := range c.Services {
+		for ctr, info := range containers {
+			field := fmt.Sprintf("services.%s.%s", svc, ctr)
+			if info.Min != nil && *info.Min < 0 {
+				add(field+".min", "must not be negative")
+			}
+			if info.Max != nil && *info.Max < 0 {
+				add(field+".max", "must not be negative")
+			}
+			if info.Min != nil && info.Max != nil && *info.Min > *info.Max {
+				add(field, "min %d is greater than max %d", *info.Min, *info.Max)
+			}
+		}
+	}
+
+	for k, name := range c.Labels {
+		if name != "" && !labelNameRe.MatchString(name) {
+			add("labels."+k, "%q is not a valid label name", name)
+		}
+	}
+	if c.LabelValuesLimit < 0 {
+		add("label_values_limit", "must not be negative")
+	}
+	switch c.LabelOverflow {
+	case "", collectors.OverflowDrop, collectors.OverflowBucket:
+	default:
+		add("label_overflow", "must be one of %q, %q", collectors.OverflowDrop, collectors.OverflowBucket)
+	}
+	switch c.Revisions.Mode {
+	case "", collectors.RevisionsJoined, collectors.RevisionsInfo:
+	case collectors.RevisionsLabels:
+		if len(c.Revisions.Components) == 0 {
+			add("revisions.components", "must not be empty in %q mode", collectors.RevisionsLabels)
+		}
+	default:
+		add("revisions.mode", "must be one of %q, %q, %q", collectors.RevisionsJoined, collectors.RevisionsLabels, collectors.RevisionsInfo)
+	}
+
+	for field, d := range map[string]Duration{
+		"resync_interval":            c.ResyncInterval,
+		"events_reconnect_max_delay": c.EventsReconnectMaxDelay,
+		"forget_grace_period":        c.ForgetGracePeriod,
+		"state_flush_interval":       c.StateFlushInterval,
+		"crashloop.window":           c.CrashLoop.Window,
+		"crashloop.stable_run":       c.CrashLoop.StableRun,
+		"scrape_timeout":             c.ScrapeTimeout,
+	} {
+		if d < 0 {
+			add(field, "must not be negative")
+		}
+	}
+	for field, n := range map[string]int{
+		"event_workers":       c.EventWorkers,
+		"inspect_concurrency": c.InspectConcurrency,
+		"crashloop.threshold": c.CrashLoop.Threshold,
+		"collection_workers":  c.CollectionWorkers,
+	} {
+		if n < 0 {
+			add(field, "must not be negative")
+		}
+	}
+
+	collectorConfigs := map[string]CollectorConfig{
+		"collectors.cpu":          c.Collectors.CPU,
+		"collectors.mem":          c.Collectors.Mem,
+		"collectors.alive":        c.Collectors.Alive,
+		"collectors.restarts":     c.Collectors.Restarts,
+		"collectors.crashloop":    c.Collectors.CrashLoop,
+		"collectors.docker_space": c.Collectors.DockerSpace,
+	}
+	tf := c.Collectors.Textfile
+	collectorConfigs["collectors.textfile"] = tf.collectorConfig()
+	if tf.Enabled != nil && *tf.Enabled && tf.Directory == "" && tf.MountPath == "" {
+		add("collectors.textfile", "directory or mount_path must be set")
+	}
+	if tf.MountPath != "" && !filepath.IsAbs(tf.MountPath) {
+		add("collectors.textfile.mount_path", "must be absolute")
+	}
+	peaks := c.Collectors.Peaks
+	collectorConfigs["collectors.peaks"] = CollectorConfig{Services: peaks.Services, ExcludeServices: peaks.ExcludeServices}
+	if peaks.Interval < 0 {
+		add("collectors.peaks.interval", "must not be negative")
+	}
+	interval := peaks.Interval.Duration()
+	if interval == 0 {
+		interval = collectors.DefaultPeakInterval
+	}
+	// samples older than MaxPeakSamples intervals are dropped
+	if peaks.Window < 0 {
+		add("collectors.peaks.window", "must not be negative")
+	} else if peaks.Window > 0 && peaks.Window.Duration() < interval {
+		add("collectors.peaks.window", "must not be shorter than interval")
+	} else if max := interval * collectors.MaxPeakSamples; interval > 0 && peaks.Window.Duration() > max {
+		add("collectors.peaks.window", "must not be longer than %d intervals (%s)", collectors.MaxPeakSamples, max)
+	}
+	for name, cc := range c.Collectors.Custom {
+		collectorConfigs["collectors.custom."+name] = cc
+	}
+	for field, cc := range collectorConfigs {
+		perContainer := field == "collectors.cpu" || field == "collectors.mem" || field == "collectors.textfile" || field == "collectors.peaks" ||
+			strings.HasPrefix(field, "collectors.custom.")
+		if cc.Timeout < 0 {
+			add(field+".timeout", "must not be negative")
+		} else if cc.Timeout > 0 && !perContainer && field != "collectors.docker_space" {
+			add(field+".timeout", "is not supported")
+		}
+		if cc.SampleInterval < 0 {
+			add(field+".sample_interval", "must not be negative")
+		} else if cc.SampleInterval > 0 && !perContainer && field != "collectors.docker_space" {
+			add(field+".sample_interval", "is not supported")
+		}
+		if !perContainer && (len(cc.Services) > 0 || len(cc.ExcludeServices) > 0) {
+			add(field, "service filters are not supported")
+		} else if _, err := collectors.NewServiceFilter(cc.Services, cc.ExcludeServices); err != nil {
+			add(field, "%v", err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
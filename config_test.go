@@ -0,0 +1,223 @@
+package aleh
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gojuno/aleh/collectors"
+)
+
+func TestApplyEnv(t *testing.T) {
+	enabled := true
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected func(c *Config)
+		err      bool
+	}{
+		{
+			name:     "no env keeps config",
+			expected: func(c *Config) {},
+		},
+		{
+			name: "top level fields",
+			env: map[string]string{
+				"ALEH_ENDPOINT":           "127.0.0.1:9100",
+				"ALEH_LABEL_VALUES_LIMIT": "10",
+				"ALEH_DOCKER_TLS_VERIFY":  "true",
+				"ALEH_RESYNC_INTERVAL":    "5m",
+			},
+			expected: func(c *Config) {
+				c.Endpoint = "127.0.0.1:9100"
+				c.LabelValuesLimit = 10
+				c.DockerTLSVerify = true
+				c.ResyncInterval = Duration(5 * time.Minute)
+			},
+		},
+		{
+			name: "nested fields are joined with underscore",
+			env: map[string]string{
+				"ALEH_CRASHLOOP_THRESHOLD":             "5",
+				"ALEH_COLLECTORS_CPU_ENABLED":          "true",
+				"ALEH_COLLECTORS_TEXTFILE_MOUNT_PATH":  "/metrics",
+				"ALEH_COLLECTORS_DOCKER_SPACE_TIMEOUT": "3s",
+			},
+			expected: func(c *Config) {
+				c.CrashLoop.Threshold = 5
+				c.Collectors.CPU.Enabled = &enabled
+				c.Collectors.Textfile.MountPath = "/metrics"
+				c.Collectors.DockerSpace.Timeout = Duration(3 * time.Second)
+			},
+		},
+		{
+			name: "maps and lists are JSON",
+			env: map[string]string{
+				"ALEH_LABELS":                          `{"team": "team"}`,
+				"ALEH_COLLECTORS_MEM_EXCLUDE_SERVICES": `["batch-*"]`,
+			},
+			expected: func(c *Config) {
+				c.Labels = map[string]string{"team": "team"}
+				c.Collectors.Mem.ExcludeServices = []string{"batch-*"}
+			},
+		},
+		{
+			name: "dashes of tags are underscores",
+			env:  map[string]string{"ALEH_SERVICES": `{"svc": {"web": {"skip-running": true}}}`},
+			expected: func(c *Config) {
+				c.Services = map[string]map[string]collectors.ContainerInfo{"svc": {"web": {SkipRunning: &enabled}}}
+			},
+		},
+		{
+			name: "invalid int",
+			env:  map[string]string{"ALEH_EVENT_WORKERS": "many"},
+			err:  true,
+		},
+		{
+			name: "invalid duration",
+			env:  map[string]string{"ALEH_SCRAPE_TIMEOUT": "soon"},
+			err:  true,
+		},
+		{
+			name: "invalid JSON",
+			env:  map[string]string{"ALEH_LABELS": `{"team"`},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{Endpoint: "0.0.0.0:1234"}
+			err := applyEnv(reflect.ValueOf(&c).Elem(), EnvPrefix, func(name string) (string, bool) {
+				v, ok := tt.env[name]
+				return v, ok
+			})
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := Config{Endpoint: "0.0.0.0:1234"}
+			tt.expected(&expected)
+			if !reflect.DeepEqual(c, expected) {
+				t.Errorf("got %+v, expected %+v", c, expected)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	enabled := true
+	negative, one, two := -1, 1, 2
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		fields []string
+	}{
+		{
+			name:   "defaults are valid",
+			modify: func(c *Config) {},
+		},
+		{
+			name: "connection settings",
+			modify: func(c *Config) {
+				c.DockerHost = "ftp://host"
+				c.DockerAPIVersion = "2"
+				c.Endpoint = "localhost"
+				c.MetricPrefix = "1aleh"
+			},
+			fields: []string{"docker_api_version", "docker_host", "endpoint", "metric_prefix"},
+		},
+		{
+			name: "services limits",
+			modify: func(c *Config) {
+				c.Services = map[string]map[string]collectors.ContainerInfo{
+					"svc": {"web": {Min: &negative}, "worker": {Min: &two, Max: &one}},
+				}
+			},
+			fields: []string{"services.svc.web.min", "services.svc.worker"},
+		},
+		{
+			name: "labels and revisions",
+			modify: func(c *Config) {
+				c.Labels = map[string]string{"team": "te-am"}
+				c.LabelValuesLimit = -1
+				c.LabelOverflow = "truncate"
+				c.Revisions.Mode = collectors.RevisionsLabels
+			},
+			fields: []string{"label_overflow", "label_values_limit", "labels.team", "revisions.components"},
+		},
+		{
+			name: "negative durations and amounts",
+			modify: func(c *Config) {
+				c.ResyncInterval = Duration(-time.Second)
+				c.CrashLoop.Threshold = -1
+			},
+			fields: []string{"crashloop.threshold", "resync_interval"},
+		},
+		{
+			name: "collector options",
+			modify: func(c *Config) {
+				c.Collectors.Alive.Timeout = Duration(time.Second)
+				c.Collectors.Restarts.Services = []string{"svc"}
+				c.Collectors.Mem.SampleInterval = Duration(-time.Second)
+				c.Collectors.Textfile.Enabled = &enabled
+			},
+			fields: []string{"collectors.alive.timeout", "collectors.mem.sample_interval", "collectors.restarts", "collectors.textfile"},
+		},
+		{
+			name: "peaks window is limited by interval",
+			modify: func(c *Config) {
+				c.Collectors.Peaks.Interval = Duration(time.Second)
+				c.Collectors.Peaks.Window = Duration(time.Hour)
+			},
+			fields: []string{"collectors.peaks.window"},
+		},
+		{
+			name: "peaks window is not shorter than interval",
+			modify: func(c *Config) {
+				c.Collectors.Peaks.Interval = Duration(time.Minute)
+				c.Collectors.Peaks.Window = Duration(time.Second)
+			},
+			fields: []string{"collectors.peaks.window"},
+		},
+		{
+			name: "peaks window is not shorter than default interval",
+			modify: func(c *Config) {
+				c.Collectors.Peaks.Window = Duration(500 * time.Millisecond)
+			},
+			fields: []string{"collectors.peaks.window"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{DockerHost: "unix:///var/run/docker.sock"}
+			c.setDefaults()
+			tt.modify(&c)
+
+			err := c.Validate()
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			verr, ok := err.(ValidationError)
+			if !ok {
+				t.Fatalf("expected ValidationError, got %v", err)
+			}
+			fields := []string{}
+			for _, fe := range verr {
+				fields = append(fields, fe.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("invalid fields %v, expected %v: %v", fields, tt.fields, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package aleh
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"olympos.io/encoding/edn"
+)
+
+// Duration is a time.Duration written in config as a string like "30s" or "5m".
+type Duration time.Duration
+
+// UnmarshalEDN edn.Unmarshaler interface implementation
+func (d *Duration) UnmarshalEDN(bs []byte) error {
+	var s string
+	if err := edn.Unmarshal(bs, &s); err != nil {
+		return errors.Wrapf(err, "duration %s must be a string", string(bs))
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Duration returns d as time.Duration.
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// UnmarshalText encoding.TextUnmarshaler interface implementation, used by YAML, JSON and environment overrides
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse duration %q", string(text))
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText encoding.TextMarshaler interface implementation
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
@@ -0,0 +1,265 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaxAPIVersion is the latest docker API version aleh uses, older one is used when daemon does not support it.
+	MaxAPIVersion = "1.41"
+
+	defaultHTTPPort  = "2375"
+	defaultHTTPSPort = "2376"
+
+	// negotiateTimeout limits API version negotiation shared by concurrent requests
+	negotiateTimeout = 10 * time.Second
+)
+
+var apiVersionValueRe = regexp.MustCompile(`^1\.[0-9]+$`)
+
+// Config describes connection to docker daemon like DOCKER_HOST, DOCKER_TLS, DOCKER_TLS_VERIFY and DOCKER_CERT_PATH env vars do.
+type Config struct {
+	// Host is unix:///path/to/socket or tcp://host:port
+	Host string
+	// TLS enables TLS without verifying the daemon certificate
+	TLS bool
+	// TLSVerify enables TLS verifying the daemon certificate with ca.pem from CertPath
+	TLSVerify bool
+	// CertPath is the directory with ca.pem, cert.pem and key.pem, client certificate is used when it exists
+	CertPath string
+	// APIVersion pins API version like 1.24, it is negotiated with the daemon when empty
+	APIVersion string
+}
+
+// Client requests docker daemon API, paths are prefixed with API version negotiated on the first request.
+type Client struct {
+	httpc   http.Client
+	baseURL string
+
+	mu          sync.Mutex
+	version     string // like /v1.41, empty for daemons without versioned API
+	resolved    bool
+	negotiation *negotiation // in progress, nil otherwise
+}
+
+// negotiation is the API version request shared by requests waiting for the version.
+type negotiation struct {
+	done    chan struct{}
+	version string
+	err     error
+}
+
+// NewClient creates client of the daemon, requests are instrumented with m unless it is nil.
+func NewClient(c Config, m *Metrics) (*Client, error) {
+	network, addr, err := ParseHost(c.Host)
+	if err != nil {
+		return nil, err
+	}
+	if c.APIVersion != "" && !apiVersionValueRe.MatchString(c.APIVersion) {
+		return nil, errors.Errorf("invalid docker API version %q", c.APIVersion)
+	}
+
+	transport := &http.Transport{}
+	baseURL := "http://docker"
+	switch network {
+	case "unix":
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr)
+		}
+	case "tcp":
+		port := defaultHTTPPort
+		tlsEnabled := c.TLS || c.TLSVerify
+		if tlsEnabled {
+			port = defaultHTTPSPort
+		}
+		if u, _ := url.Parse("tcp://" + addr); u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), port)
+		}
+		baseURL = "http://" + addr
+		if tlsEnabled {
+			tlsConfig, err := clientTLSConfig(c.CertPath, c.TLSVerify)
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig = tlsConfig
+			baseURL = "https://" + addr
+			if !c.TLSVerify {
+				log.Printf("WARN: docker daemon certificate of %s is not verified", addr)
+			}
+		}
+	}
+
+	client := &Client{
+		httpc:   http.Client{Transport: m.RoundTripper(transport)},
+		baseURL: baseURL,
+	}
+	if c.APIVersion != "" {
+		client.version, client.resolved = "/v"+c.APIVersion, true
+	}
+	return client, nil
+}
+
+// ParseHost returns network and address of docker daemon host, port of tcp address is optional.
+func ParseHost(host string) (network, addr string, err error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "invalid docker host %q", host)
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return "", "", errors.Errorf("docker host %q has no socket path", host)
+		}
+		return "unix", u.Path, nil
+	case "tcp":
+		if u.Host == "" {
+			return "", "", errors.Errorf("docker host %q has no address", host)
+		}
+		return "tcp", u.Host, nil
+	}
+	return "", "", errors.Errorf("docker host %q should be unix:// or tcp://", host)
+}
+
+// clientTLSConfig loads client certificate and CA from the docker cert directory.
+func clientTLSConfig(certPath string, verify bool) (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: !verify}
+	if verify {
+		ca, err := ioutil.ReadFile(filepath.Join(certPath, "ca.pem"))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read docker CA certificate")
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("no certificates in %s", filepath.Join(certPath, "ca.pem"))
+		}
+	}
+	if certPath == "" && !verify {
+		return c, nil
+	}
+	certFile, keyFile := filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem")
+	if _, err := os.Stat(certFile); os.IsNotExist(err) && !verify {
+		return c, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load docker client certificate")
+	}
+	c.Certificates = []tls.Certificate{cert}
+	return c, nil
+}
+
+// apiVersion returns the version prefix of request paths negotiating it with the daemon if needed.
+// Concurrent requests share the negotiation, every one waits for it until its own ctx is done.
+func (c *Client) apiVersion(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if c.resolved {
+		version := c.version
+		c.mu.Unlock()
+		return version, nil
+	}
+	n := c.negotiation
+	if n == nil {
+		n = &negotiation{done: make(chan struct{})}
+		c.negotiation = n
+		go c.negotiateVersion(n)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-n.done:
+		return n.version, n.err
+	case <-ctx.Done():
+		return "", &RequestError{Path: "/_ping", Err: ctx.Err()}
+	}
+}
+
+// negotiateVersion negotiates the version independently of requests waiting for it, failed negotiation
+// is repeated by the next request.
+func (c *Client) negotiateVersion(n *negotiation) {
+	ctx, cancel := context.WithTimeout(context.Background(), negotiateTimeout)
+	defer cancel()
+
+	version, err := c.negotiate(ctx)
+	if err == nil && version != "" {
+		if lessVersion(MaxAPIVersion, version) {
+			version = MaxAPIVersion
+		}
+		version = "/v" + version
+	}
+
+	c.mu.Lock()
+	if err == nil {
+		c.version, c.resolved = version, true
+	}
+	c.negotiation = nil
+	c.mu.Unlock()
+
+	n.version, n.err = version, err
+	close(n.done)
+}
+
+// negotiate asks the daemon API version with /_ping falling back to /version for old daemons.
+// Empty version means the daemon does not report it and unversioned paths are used.
+func (c *Client) negotiate(ctx context.Context) (string, error) {
+	resp, err := c.do(ctx, "/_ping")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if v := resp.Header.Get("API-Version"); apiVersionValueRe.MatchString(v) {
+		return v, nil
+	}
+
+	resp, err = c.do(ctx, "/version")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var v struct {
+		APIVersion string `json:"ApiVersion"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&v) != nil || !apiVersionValueRe.MatchString(v.APIVersion) {
+		return "", nil
+	}
+	return v.APIVersion, nil
+}
+
+func (c *Client) do(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.baseURL+path, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to build http req for %s", path)
+	}
+	resp, err := c.httpc.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, &RequestError{Path: path, Err: err}
+	}
+	return resp, nil
+}
+
+// lessVersion compares API versions like 1.9 and 1.24.
+func lessVersion(a, b string) bool {
+	return minorVersion(a) < minorVersion(b)
+}
+
+func minorVersion(v string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(v, "1."))
+	return n
+}
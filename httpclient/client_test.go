@@ -0,0 +1,202 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		network string
+		addr    string
+		err     bool
+	}{
+		{host: "unix:///var/run/docker.sock", network: "unix", addr: "/var/run/docker.sock"},
+		{host: "tcp://10.0.0.1:2376", network: "tcp", addr: "10.0.0.1:2376"},
+		{host: "tcp://docker.local", network: "tcp", addr: "docker.local"},
+		{host: "tcp://[::1]:2375", network: "tcp", addr: "[::1]:2375"},
+		{host: "unix://", err: true},
+		{host: "tcp://", err: true},
+		{host: "http://docker.local:2375", err: true},
+		{host: "/var/run/docker.sock", err: true},
+		{host: "tcp://host:port:%", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			network, addr, err := ParseHost(tt.host)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got %s %s", network, addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if network != tt.network || addr != tt.addr {
+				t.Errorf("got %s %s, expected %s %s", network, addr, tt.network, tt.addr)
+			}
+		})
+	}
+}
+
+func TestAPIVersionNegotiation(t *testing.T) {
+	tests := []struct {
+		name        string
+		pinned      string
+		pingVersion string
+		version     string
+		path        string
+		pings       int
+	}{
+		{name: "ping header", pingVersion: "1.41", path: "/v1.41/info", pings: 1},
+		{name: "older daemon", pingVersion: "1.24", path: "/v1.24/info", pings: 1},
+		{name: "newer daemon is capped", pingVersion: "1.45", path: "/v" + MaxAPIVersion + "/info", pings: 1},
+		{name: "version endpoint fallback", version: `{"ApiVersion": "1.19"}`, path: "/v1.19/info", pings: 1},
+		{name: "invalid ping header falls back to version endpoint", pingVersion: "latest", version: `{"ApiVersion": "1.22"}`, path: "/v1.22/info", pings: 1},
+		{name: "unversioned daemon", version: `{}`, path: "/info", pings: 1},
+		{name: "pinned version is not negotiated", pinned: "1.30", pingVersion: "1.41", path: "/v1.30/info"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			pings := 0
+			paths := []string{}
+			daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/_ping":
+					mu.Lock()
+					pings++
+					mu.Unlock()
+					if tt.pingVersion != "" {
+						w.Header().Set("API-Version", tt.pingVersion)
+					}
+					w.Write([]byte("OK"))
+				case "/version":
+					if tt.version == "" {
+						http.NotFound(w, r)
+						return
+					}
+					w.Write([]byte(tt.version))
+				default:
+					mu.Lock()
+					paths = append(paths, r.URL.Path)
+					mu.Unlock()
+					w.Write([]byte("{}"))
+				}
+			}))
+			defer daemon.Close()
+
+			c, err := NewClient(Config{Host: "tcp://" + strings.TrimPrefix(daemon.URL, "http://"), APIVersion: tt.pinned}, nil)
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			// the version is negotiated once
+			for i := 0; i < 2; i++ {
+				var v struct{}
+				if err := c.GetJSON(context.Background(), "/info", &v); err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if pings != tt.pings {
+				t.Errorf("daemon pinged %d times, expected %d", pings, tt.pings)
+			}
+			if len(paths) != 2 {
+				t.Fatalf("requested %v, expected 2 requests", paths)
+			}
+			for _, p := range paths {
+				if p != tt.path {
+					t.Errorf("requested %s, expected %s", p, tt.path)
+				}
+			}
+		})
+	}
+}
+
+func TestNegotiationRespectsRequestContext(t *testing.T) {
+	hang := make(chan struct{})
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_ping" {
+			<-hang
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer daemon.Close()
+	defer close(hang)
+
+	c, err := NewClient(Config{Host: "tcp://" + strings.TrimPrefix(daemon.URL, "http://")}, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// the first request waiting for the hanging negotiation does not block others
+	go c.GetJSON(context.Background(), "/events", &struct{}{})
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			started := time.Now()
+			err := c.GetJSON(ctx, "/info", &struct{}{})
+			if !IsUnreachable(err) {
+				t.Errorf("expected unreachable error, got %v", err)
+			}
+			if d := time.Since(started); d > time.Second {
+				t.Errorf("request returned after %v", d)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTLSSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		baseURL  string
+		insecure bool
+	}{
+		{name: "plain tcp", config: Config{Host: "tcp://docker.local"}, baseURL: "http://docker.local:2375"},
+		{name: "cert path alone does not enable TLS", config: Config{Host: "tcp://docker.local", CertPath: t.TempDir()}, baseURL: "http://docker.local:2375"},
+		{name: "unverified TLS", config: Config{Host: "tcp://docker.local", TLS: true}, baseURL: "https://docker.local:2376", insecure: true},
+		{name: "explicit port", config: Config{Host: "tcp://docker.local:4243", TLS: true}, baseURL: "https://docker.local:4243", insecure: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(tt.config, nil)
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			if c.baseURL != tt.baseURL {
+				t.Errorf("base URL %s, expected %s", c.baseURL, tt.baseURL)
+			}
+			var insecure bool
+			if tc := c.httpc.Transport.(*http.Transport).TLSClientConfig; tc != nil {
+				insecure = tc.InsecureSkipVerify
+			}
+			if insecure != tt.insecure {
+				t.Errorf("insecure %v, expected %v", insecure, tt.insecure)
+			}
+		})
+	}
+}
+
+func TestInvalidPinnedVersion(t *testing.T) {
+	if _, err := NewClient(Config{Host: "unix:///var/run/docker.sock", APIVersion: "v1.24"}, nil); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,89 @@
+package httpclient
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	apiVersionRe  = regexp.MustCompile(`^/v[0-9.]+/`)
+	containerIDRe = regexp.MustCompile(`^/containers/[^/]+/`)
+)
+
+// Metrics instruments docker API requests by endpoint.
+type Metrics struct {
+	duration *prometheus.HistogramVec
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+}
+
+func NewMetrics(metricPrefix string) *Metrics {
+	return &Metrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    metricPrefix + "docker_api_request_duration_seconds",
+			Help:    "Docker API request latency till response headers",
+			Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10},
+		}, []string{"endpoint"}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: metricPrefix + "docker_api_requests_total",
+			Help: "Amount of docker API responses by status code",
+		}, []string{"endpoint", "code"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: metricPrefix + "docker_api_request_errors_total",
+			Help: "Amount of docker API requests failed without response",
+		}, []string{"endpoint"}),
+	}
+}
+
+// Endpoint returns the request path with API version and container ID stripped, e.g. /containers/{id}/json.
+func Endpoint(path string) string {
+	path = apiVersionRe.ReplaceAllString(path, "/")
+	if !strings.HasPrefix(path, "/containers/json") {
+		path = containerIDRe.ReplaceAllString(path, "/containers/{id}/")
+	}
+	return path
+}
+
+// Describe prometheus.Collector interface implementation
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.duration.Describe(ch)
+	m.requests.Describe(ch)
+	m.errors.Describe(ch)
+}
+
+// Collect prometheus.Collector interface implementation
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.duration.Collect(ch)
+	m.requests.Collect(ch)
+	m.errors.Collect(ch)
+}
+
+// RoundTripper instruments next, nil Metrics leaves it as is.
+func (m *Metrics) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if m == nil {
+		return next
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		endpoint := Endpoint(req.URL.Path)
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		m.duration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+		if err != nil {
+			m.errors.WithLabelValues(endpoint).Inc()
+			return nil, err
+		}
+		m.requests.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+		return resp, nil
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
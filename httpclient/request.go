@@ -0,0 +1,117 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RequestError is returned when docker did not respond, the daemon is probably unreachable.
+type RequestError struct {
+	Path string
+	Err  error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("request to %s failed: %v", e.Path, e.Err)
+}
+
+// StatusError is returned when docker responded with unexpected status code.
+type StatusError struct {
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d from %s: %s", e.StatusCode, e.Path, e.Message)
+}
+
+// DecodeError is returned when docker response can not be decoded.
+type DecodeError struct {
+	Path string
+	Body string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode response of %s `%s`: %v", e.Path, e.Body, e.Err)
+}
+
+// IsNotFound reports whether err is caused by 404 response, e.g. for already removed container.
+func IsNotFound(err error) bool {
+	se, ok := errors.Cause(err).(*StatusError)
+	return ok && se.StatusCode == http.StatusNotFound
+}
+
+// IsUnreachable reports whether err is caused by docker not responding.
+func IsUnreachable(err error) bool {
+	_, ok := errors.Cause(err).(*RequestError)
+	return ok
+}
+
+// IsTransient reports whether the request failed with err could succeed if retried.
+func IsTransient(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *RequestError:
+		return true
+	case *StatusError:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// GetJSON requests docker API path and decodes JSON response into v.
+// Errors are RequestError, StatusError or DecodeError, v should not be used on error.
+func (c *Client) GetJSON(ctx context.Context, path string, v interface{}) error {
+	resp, err := c.Get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &RequestError{Path: path, Err: err}
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return &DecodeError{Path: path, Body: string(body), Err: err}
+	}
+	return nil
+}
+
+// Get requests docker API path, the response body should be closed if there is no error.
+// Errors are RequestError or StatusError for responses other than 200.
+func (c *Client) Get(ctx context.Context, path string) (*http.Response, error) {
+	version, err := c.apiVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, version+path)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &StatusError{Path: path, StatusCode: resp.StatusCode, Message: statusMessage(body)}
+	}
+	return resp, nil
+}
+
+// statusMessage extracts message of docker error response.
+func statusMessage(body []byte) string {
+	var e struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &e); err == nil && e.Message != "" {
+		return e.Message
+	}
+	return strings.TrimSpace(string(body))
+}
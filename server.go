@@ -2,62 +2,530 @@ package aleh
 
 import (
 	"context"
+	"log"
 	"net/http"
-	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gojuno/aleh/collectors"
+	"github.com/gojuno/aleh/httpclient"
 	"github.com/gojuno/aleh/storages"
 
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-type Config struct {
-	DockerDaemonSocket string                                         `edn:"docker_daemon_socket"`
-	Endpoint           string                                         `edn:"endpoint"`
-	MetricPrefix       string                                         `edn:"metric_prefix"`
-	Services           map[string]map[string]collectors.ContainerInfo `edn:"services"`
+// Options are optional settings of Server.
+type Options struct {
+	// Registerer registers collectors, prometheus.DefaultRegisterer when nil
+	Registerer prometheus.Registerer
+	// Gatherer serves /metrics. When nil Registerer is used, it should be a Gatherer then.
+	Gatherer prometheus.Gatherer
+	// ContainerCollectors are custom per-container collectors, they are configured
+	// in collectors custom config section by name and enabled by default.
+	ContainerCollectors []collectors.ContainerCollector
 }
 
 // Server implements net/http.Handler
 // It registers all needed prometheus collectors
 // and handles http GET /metrics for prometheus
 // and http GET /internal for debug purposes
+// and http GET /healthz and /readyz for liveness and readiness probes
 type Server struct {
-	mux *http.ServeMux
+	mux        *http.ServeMux
+	registerer prometheus.Registerer
+	gatherer   prometheus.Gatherer
+	registered []prometheus.Collector
+	ctx        context.Context
+	cancel     context.CancelFunc
+	closeOnce  sync.Once
+	storage    *storages.InmemoryStorage
+	docker     *httpclient.Client
+	custom     []collectors.ContainerCollector
+	// reservedNames are metrics gathered before aleh collectors are registered, text files can't report them
+	reservedNames map[string]bool
+
+	// mu guards config and collectors, scrapes hold the read lock so that they never see half swapped collectors
+	mu                sync.RWMutex
+	config            Config
+	collectors        *collectorSet
+	reloadSuccess     prometheus.Gauge
+	reloadSuccessTime prometheus.Gauge
 }
 
+// New creates Server registering collectors in the default prometheus registry, it panics on error.
+// Use NewServer to embed aleh.
 func New(ctx context.Context, c Config) *Server {
-	s := &Server{mux: http.NewServeMux()}
+	s, err := NewServer(ctx, c, Options{})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewServer creates Server and starts watching docker. Background work stops on ctx cancellation or Close.
+// Empty config fields are set to defaults.
+func NewServer(ctx context.Context, c Config, opts Options) (*Server, error) {
+	c.setDefaults()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+	if opts.Gatherer == nil {
+		g, ok := opts.Registerer.(prometheus.Gatherer)
+		if !ok {
+			return nil, errors.New("Gatherer option is required when Registerer is not a Gatherer")
+		}
+		opts.Gatherer = g
+	}
 
-	containerListener := storages.New(ctx, c.DockerDaemonSocket)
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Server{
+		mux:        http.NewServeMux(),
+		config:     c,
+		registerer: opts.Registerer,
+		gatherer:   opts.Gatherer,
+		ctx:        ctx,
+		cancel:     cancel,
+		custom:     opts.ContainerCollectors,
+		reloadSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: c.MetricPrefix + "config_last_reload_success",
+			Help: "Whether the last config reload succeeded",
+		}),
+		reloadSuccessTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: c.MetricPrefix + "config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful config reload",
+		}),
+	}
+	if err := s.start(ctx, c); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
 
-	// cpu
-	if v := os.Getenv("CPU_STATS"); v == "true" {
-		cpuStatCollector := collectors.NewCPUCollector(c.MetricPrefix, containerListener)
-		prometheus.MustRegister(cpuStatCollector)
+func (s *Server) start(ctx context.Context, c Config) error {
+	s.reloadSuccess.Set(1)
+	s.reloadSuccessTime.Set(float64(time.Now().Unix()))
+	if err := s.register("config reload", s.reloadSuccess, s.reloadSuccessTime); err != nil {
+		return err
 	}
 
-	// mem
-	memStatCollector := collectors.NewMemCollector(c.MetricPrefix, containerListener)
-	prometheus.MustRegister(memStatCollector)
+	apiMetrics := httpclient.NewMetrics(c.MetricPrefix)
+	if err := s.register("docker api", apiMetrics); err != nil {
+		return err
+	}
+
+	docker, err := httpclient.NewClient(httpclient.Config{
+		Host:       c.DockerHost,
+		TLS:        c.DockerTLS,
+		TLSVerify:  c.DockerTLSVerify,
+		CertPath:   c.DockerCertPath,
+		APIVersion: c.DockerAPIVersion,
+	}, apiMetrics)
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker client")
+	}
+
+	containerListener := storages.New(ctx, docker, storages.Options{
+		RevisionLabelPrefix:     c.Revisions.LabelPrefix,
+		ResyncInterval:          c.ResyncInterval.Duration(),
+		EventsReconnectMaxDelay: c.EventsReconnectMaxDelay.Duration(),
+		EventWorkers:            c.EventWorkers,
+		InspectConcurrency:      c.InspectConcurrency,
+		ForgetGracePeriod:       c.ForgetGracePeriod.Duration(),
+		MetricPrefix:            c.MetricPrefix,
+	})
+	if err := s.register("storage", containerListener); err != nil {
+		return err
+	}
+	s.storage, s.docker = containerListener, docker
+	s.reservedNames = gatheredNames(s.gatherer)
+
+	set, err := s.buildCollectors(c)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	err = s.swapCollectors(set)
+	s.mu.Unlock()
+	if err != nil {
+		s.discardCollectors(set)
+		return err
+	}
+
+	metrics := promhttp.HandlerFor(s.gatherer, promhttp.HandlerOpts{})
+	s.mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		// collectors are not gathered while reload swaps them
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		metrics.ServeHTTP(w, r)
+	})
+	s.mux.HandleFunc("/internal", containerListener.HttpHandler())
+	s.mux.HandleFunc("/healthz", healthHandler(ctx))
+	s.mux.HandleFunc("/readyz", readyHandler(ctx, containerListener))
+	return nil
+}
+
+// gatheredNames returns names of metric families of g, e.g. go_* and process_* of the default registry.
+func gatheredNames(g prometheus.Gatherer) map[string]bool {
+	families, err := g.Gather()
+	if err != nil {
+		log.Printf("ERROR: failed to gather metrics: %v", err)
+	}
+	names := map[string]bool{}
+	for _, mf := range families {
+		names[mf.GetName()] = true
+	}
+	return names
+}
+
+// healthHandler reports liveness, aleh is alive until closed.
+func healthHandler(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ctx.Err() != nil {
+			http.Error(w, "closed", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// readyHandler reports readiness, aleh is ready when containers are loaded and docker events are watched.
+func readyHandler(ctx context.Context, l *storages.InmemoryStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ctx.Err() != nil {
+			http.Error(w, "closed", http.StatusServiceUnavailable)
+			return
+		}
+		if err := l.Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// buildCollectors creates collectors configured by collector and metric settings of c, they are not registered.
+// Restarts and crashloop collectors currently registered are reused.
+func (s *Server) buildCollectors(c Config) (*collectorSet, error) {
+	labels := collectors.NewContainerLabels(c.MetricPrefix, c.Labels, c.LabelValuesLimit, c.LabelOverflow, c.Revisions)
+	ctx, cancel := context.WithCancel(s.ctx)
+	set := &collectorSet{cancel: cancel, labels: labels}
+	set.collectors = append(set.collectors, namedCollector{"labels", labels})
+
+	cc := c.Collectors
+	pool := collectors.NewWorkerPool(ctx, c.CollectionWorkers)
+
+	// per-container stats
+	containerCollectors := []containerCollectorSetting{
+		{collectors.NewCPUCollector(), cc.CPU, cc.CPU.IsEnabled(false)},
+		{collectors.NewMemCollector(), cc.Mem, cc.Mem.IsEnabled(true)},
+	}
+	for _, cs := range s.custom {
+		config := cc.Custom[cs.Name()]
+		containerCollectors = append(containerCollectors, containerCollectorSetting{cs, config, config.IsEnabled(true)})
+	}
+	names := map[string]bool{}
+	for _, cs := range containerCollectors {
+		name := cs.collector.Name()
+		if names[name] {
+			cancel()
+			return nil, errors.Errorf("duplicate container collector %q", name)
+		}
+		names[name] = true
+		if !cs.enabled {
+			continue
+		}
+		stats, err := collectors.NewContainerStatsCollector(c.MetricPrefix, s.storage, labels, cs.collector, containerCollectorOptions(cs.config, pool, c.ScrapeTimeout))
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		set.add(name, wrap(ctx, c.MetricPrefix, name, stats, cs.config))
+	}
+
+	// textfile
+	if tf := cc.Textfile; tf.Enabled != nil && *tf.Enabled {
+		textfileCollector := collectors.NewTextfileCollector(c.MetricPrefix, s.storage, labels, collectors.TextfileOptions{
+			ContainerCollectorOptions: containerCollectorOptions(tf.collectorConfig(), pool, c.ScrapeTimeout),
+			Directory:                 tf.Directory,
+			MountPath:                 tf.MountPath,
+			HostRoot:                  tf.HostRoot,
+			ReservedNames:             s.reservedNames,
+		})
+		set.add("textfile", wrap(ctx, c.MetricPrefix, "textfile", textfileCollector, tf.collectorConfig()))
+	}
+
+	// peaks
+	if peaks := cc.Peaks; peaks.Enabled != nil && *peaks.Enabled {
+		filter, _ := collectors.NewServiceFilter(peaks.Services, peaks.ExcludeServices)
+		peakCollector := collectors.NewPeakCollector(ctx, c.MetricPrefix, s.storage, labels, collectors.PeakOptions{
+			Interval: peaks.Interval.Duration(),
+			Window:   peaks.Window.Duration(),
+			Filter:   filter,
+			Pool:     pool,
+		})
+		set.add("peaks", wrap(ctx, c.MetricPrefix, "peaks", peakCollector, CollectorConfig{}))
+	}
 
 	// alive
-	aliveCollector := collectors.NewAliveCollector(c.MetricPrefix, containerListener, c.Services)
-	prometheus.MustRegister(aliveCollector)
+	if cc.Alive.IsEnabled(true) {
+		alive := collectors.NewAliveCollector(c.MetricPrefix, s.storage, labels, c.Services)
+		set.add("alive", wrap(ctx, c.MetricPrefix, "alive", alive, CollectorConfig{}))
+	}
 
 	// restarts
-	restartCollector := collectors.NewRestartCollector(c.MetricPrefix, containerListener)
-	prometheus.MustRegister(restartCollector)
+	if cc.Restarts.IsEnabled(true) {
+		// restart counters survive reloads, labels of the reused collector are changed on swap
+		set.restarts = s.currentRestarts()
+		if set.restarts == nil {
+			set.restarts = collectors.NewRestartCollector(s.ctx, c.MetricPrefix, s.storage, labels, c.StateFile, c.StateFlushInterval.Duration())
+		}
+		set.add("restarts", wrap(ctx, c.MetricPrefix, "restarts", set.restarts, CollectorConfig{}))
+	}
+
+	// crashloops
+	if cc.CrashLoop.IsEnabled(true) {
+		// restarts history survives reloads too, settings are changed on swap
+		set.crashLoop = s.currentCrashLoop()
+		if set.crashLoop == nil {
+			set.crashLoop = collectors.NewCrashLoopCollector(c.MetricPrefix, s.storage, c.CrashLoop.Window.Duration(), c.CrashLoop.Threshold, c.CrashLoop.StableRun.Duration())
+		}
+		set.crashLoopSettings = c.CrashLoop
+		set.add("crashloop", wrap(ctx, c.MetricPrefix, "crashloop", set.crashLoop, CollectorConfig{}))
+	}
 
 	// docker space
-	spaceCollector := collectors.NewDockerSpaceCollector(c.MetricPrefix, c.DockerDaemonSocket)
-	prometheus.MustRegister(spaceCollector)
+	if cc.DockerSpace.IsEnabled(true) {
+		spaceCollector := collectors.NewDockerSpaceCollector(c.MetricPrefix, s.docker, cc.DockerSpace.Timeout.Duration())
+		set.add("docker_space", wrap(ctx, c.MetricPrefix, "docker_space", spaceCollector, cc.DockerSpace))
+	}
+	return set, nil
+}
 
-	s.mux.Handle("/metrics", promhttp.Handler())
-	s.mux.HandleFunc("/internal", containerListener.HttpHandler())
+// collectorSet holds collectors configured by collector and metric settings, it is replaced on reload.
+type collectorSet struct {
+	cancel            context.CancelFunc
+	labels            *collectors.ContainerLabels
+	restarts          *collectors.RestartCollector
+	crashLoop         *collectors.CrashLoopCollector
+	crashLoopSettings CrashLoopConfig
+	collectors        []namedCollector
+	enabled           []string
+}
 
-	return s
+type namedCollector struct {
+	name      string
+	collector prometheus.Collector
+}
+
+func (set *collectorSet) add(name string, c prometheus.Collector) {
+	set.collectors = append(set.collectors, namedCollector{name, c})
+	set.enabled = append(set.enabled, name)
+}
+
+// register registers all collectors of the set or none of them.
+func (set *collectorSet) register(r prometheus.Registerer) error {
+	for i, nc := range set.collectors {
+		if err := r.Register(nc.collector); err != nil {
+			for _, registered := range set.collectors[:i] {
+				r.Unregister(registered.collector)
+			}
+			return errors.Wrapf(err, "failed to register %s collector", nc.name)
+		}
+	}
+	return nil
+}
+
+func (set *collectorSet) unregister(r prometheus.Registerer) {
+	for _, nc := range set.collectors {
+		r.Unregister(nc.collector)
+	}
+}
+
+func (s *Server) currentRestarts() *collectors.RestartCollector {
+	if s.collectors == nil {
+		return nil
+	}
+	return s.collectors.restarts
+}
+
+func (s *Server) currentCrashLoop() *collectors.CrashLoopCollector {
+	if s.collectors == nil {
+		return nil
+	}
+	return s.collectors.crashLoop
+}
+
+// swapCollectors registers set instead of the current collectors and stops them, it should be called under the write lock.
+// The current collectors are kept registered when set fails to register.
+func (s *Server) swapCollectors(set *collectorSet) error {
+	old := s.collectors
+	if old != nil {
+		old.unregister(s.registerer)
+	}
+	// descriptors of the reused restarts collector depend on labels
+	if set.restarts != nil {
+		set.restarts.SetLabels(set.labels)
+	}
+	if err := set.register(s.registerer); err != nil {
+		if old != nil {
+			if old.restarts != nil {
+				old.restarts.SetLabels(old.labels)
+			}
+			if err := old.register(s.registerer); err != nil {
+				log.Printf("ERROR: failed to restore collectors: %v", err)
+			}
+		}
+		return err
+	}
+
+	if set.crashLoop != nil {
+		cl := set.crashLoopSettings
+		set.crashLoop.SetSettings(cl.Window.Duration(), cl.Threshold, cl.StableRun.Duration())
+	}
+	s.collectors = set
+	if old != nil {
+		s.discardCollectors(old)
+	}
+	log.Printf("enabled collectors: %s", strings.Join(set.enabled, ", "))
+	return nil
+}
+
+// discardCollectors stops background work of the set, restarts and crashloop collectors are closed
+// unless they are used by the current set.
+func (s *Server) discardCollectors(set *collectorSet) {
+	set.cancel()
+	if set.crashLoop != nil && set.crashLoop != s.currentCrashLoop() {
+		set.crashLoop.Close()
+	}
+	if set.restarts != nil && set.restarts != s.currentRestarts() {
+		if err := set.restarts.Close(); err != nil {
+			log.Printf("ERROR: failed to close restarts collector: %v", err)
+		}
+	}
+}
+
+// wrap instruments the collector and wraps it into background sampling when the sample interval is configured.
+func wrap(ctx context.Context, metricPrefix, name string, c prometheus.Collector, config CollectorConfig) prometheus.Collector {
+	c = collectors.NewInstrumentedCollector(metricPrefix, name, c)
+	if config.SampleInterval <= 0 {
+		return c
+	}
+	return collectors.NewSampledCollector(ctx, metricPrefix, name, c, config.SampleInterval.Duration())
+}
+
+// register registers collectors, they are unregistered on Close.
+func (s *Server) register(name string, cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := s.registerer.Register(c); err != nil {
+			return errors.Wrapf(err, "failed to register %s collector", name)
+		}
+		s.registered = append(s.registered, c)
+	}
+	return nil
+}
+
+type containerCollectorSetting struct {
+	collector collectors.ContainerCollector
+	config    CollectorConfig
+	enabled   bool
+}
+
+// containerCollectorOptions converts validated config of a per-container collector,
+// scrapeTimeout is used when the collector timeout is not set.
+func containerCollectorOptions(c CollectorConfig, pool *collectors.WorkerPool, scrapeTimeout Duration) collectors.ContainerCollectorOptions {
+	if c.Timeout == 0 {
+		c.Timeout = scrapeTimeout
+	}
+	filter, err := collectors.NewServiceFilter(c.Services, c.ExcludeServices)
+	if err != nil {
+		log.Printf("ERROR: invalid service filter, collecting all services: %v", err)
+	}
+	return collectors.ContainerCollectorOptions{
+		Timeout: c.Timeout.Duration(),
+		Filter:  filter,
+		Pool:    pool,
+	}
+}
+
+// Reload loads and validates new config and atomically replaces collectors configured by
+// services, labels, revisions, crashloop and collectors settings. Restart counters and crashloop history are kept.
+// Other settings require restart. Reload fails keeping the current collectors when label names of metrics change,
+// the registry does not allow it for registered metrics.
+func (s *Server) Reload(load func() (Config, error)) error {
+	err := s.reload(load)
+	if err != nil {
+		s.reloadSuccess.Set(0)
+		return err
+	}
+	s.reloadSuccess.Set(1)
+	s.reloadSuccessTime.Set(float64(time.Now().Unix()))
+	return nil
+}
+
+func (s *Server) reload(load func() (Config, error)) error {
+	c, err := load()
+	if err != nil {
+		return errors.Wrap(err, "failed to load config")
+	}
+	c.setDefaults()
+	if err := c.Validate(); err != nil {
+		return errors.Wrap(err, "invalid config")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return errors.New("server is closed")
+	}
+
+	applied := s.config.withReloadable(c)
+	set, err := s.buildCollectors(applied)
+	if err != nil {
+		return errors.Wrap(err, "failed to create collectors")
+	}
+	if err := s.swapCollectors(set); err != nil {
+		s.discardCollectors(set)
+		return err
+	}
+	if !reflect.DeepEqual(applied, c) {
+		log.Printf("WARN: config changes of docker, endpoint, metric prefix, storage and state settings require restart")
+	}
+	s.config = applied
+	return nil
+}
+
+// Close stops background work, saves state of collectors and unregisters them.
+// It should be called on shutdown, subsequent calls do nothing.
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.cancel()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if set := s.collectors; set != nil {
+			set.unregister(s.registerer)
+			set.cancel()
+			if set.crashLoop != nil {
+				set.crashLoop.Close()
+			}
+			if set.restarts != nil {
+				err = set.restarts.Close()
+			}
+			s.collectors = nil
+		}
+		for _, c := range s.registered {
+			s.registerer.Unregister(c)
+		}
+	})
+	return err
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
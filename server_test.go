@@ -0,0 +1,61 @@
+package aleh
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNewServerRegistrationClash(t *testing.T) {
+	r := prometheus.NewRegistry()
+	r.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "aleh_running_replicas", Help: "clash"}))
+
+	s, err := NewServer(context.Background(), Config{DockerHost: "unix:///nonexistent/docker.sock"}, Options{Registerer: r})
+	if err == nil {
+		s.Close()
+		t.Fatal("expected registration error")
+	}
+	if _, err := r.Gather(); err != nil {
+		t.Errorf("registry is broken after failed start: %v", err)
+	}
+}
+
+func TestReloadLabelNamesChange(t *testing.T) {
+	r := prometheus.NewRegistry()
+	c := Config{DockerHost: "unix:///nonexistent/docker.sock", Labels: map[string]string{"team": "team"}}
+	s, err := NewServer(context.Background(), c, Options{Registerer: r})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	c.Labels = map[string]string{"team": "team", "env": "env"}
+	if err := s.Reload(func() (Config, error) { return c, nil }); err == nil {
+		t.Error("expected reload error")
+	}
+	if _, err := r.Gather(); err != nil {
+		t.Errorf("registry is broken after failed reload: %v", err)
+	}
+
+	c.Labels = map[string]string{"owner": "team"}
+	if err := s.Reload(func() (Config, error) { return c, nil }); err != nil {
+		t.Errorf("reload with renamed container label failed: %v", err)
+	}
+	if _, err := r.Gather(); err != nil {
+		t.Errorf("registry is broken after reload: %v", err)
+	}
+}
+
+type registererOnly struct {
+	prometheus.Registerer
+}
+
+func TestNewServerRequiresGatherer(t *testing.T) {
+	r := registererOnly{prometheus.NewRegistry()}
+	s, err := NewServer(context.Background(), Config{DockerHost: "unix:///nonexistent/docker.sock"}, Options{Registerer: r})
+	if err == nil {
+		s.Close()
+		t.Fatal("expected error")
+	}
+}
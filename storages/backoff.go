@@ -0,0 +1,38 @@
+package storages
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff produces exponentially growing delays with jitter.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	attempt uint
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	if max < min {
+		max = min
+	}
+	return &backoff{min: min, max: max}
+}
+
+// next returns the delay before the next attempt.
+func (b *backoff) next() time.Duration {
+	d := b.max
+	if b.attempt < 32 {
+		if v := b.min << b.attempt; v > 0 && v < b.max {
+			d = v
+		}
+	}
+	b.attempt++
+	// half of the delay is fixed and another half is random
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// reset starts the delays sequence from the beginning.
+func (b *backoff) reset() {
+	b.attempt = 0
+}
@@ -0,0 +1,158 @@
+package storages
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultSubscriptionBuffer is the default size of subscriber queue.
+const DefaultSubscriptionBuffer = 1024
+
+// EventType is a kind of container lifecycle event.
+type EventType string
+
+const (
+	ContainerCreated EventType = "created"
+	// ContainerDiscovered is published for running containers found by listing instead of start event,
+	// e.g. on aleh start.
+	ContainerDiscovered    EventType = "discovered"
+	ContainerStarted       EventType = "started"
+	ContainerDied          EventType = "died"
+	ContainerDestroyed     EventType = "destroyed"
+	ContainerHealthChanged EventType = "health_changed"
+	ContainerOOM           EventType = "oom"
+	ContainerUpdated       EventType = "updated"
+)
+
+// LifecycleEvent describes a change of container state.
+type LifecycleEvent struct {
+	Type      EventType
+	Container Container
+	Time      time.Time
+	// ExitCode is set for ContainerDied events, it is -1 when the container stop is found by resync
+	ExitCode int
+	// Health is the new health status for ContainerHealthChanged events
+	Health string
+	// Snapshot is true for ContainerStarted events describing containers running at the moment of subscription
+	Snapshot bool
+}
+
+// SubscribeOptions configures a subscription.
+type SubscribeOptions struct {
+	// Buffer is the size of subscriber queue, DefaultSubscriptionBuffer is used when zero.
+	// Events are dropped when the queue is full.
+	Buffer int
+	// Types limits delivered events, all events are delivered when empty.
+	Types []EventType
+	// Snapshot requests ContainerStarted events for all running containers right after subscription.
+	Snapshot bool
+}
+
+// Subscription receives container lifecycle events.
+type Subscription struct {
+	name  string
+	ch    chan LifecycleEvent
+	types map[EventType]bool
+	bus   *eventBus
+}
+
+// Events returns the channel of subscription events, it is closed after Unsubscribe.
+func (s *Subscription) Events() <-chan LifecycleEvent {
+	return s.ch
+}
+
+// Unsubscribe stops events delivery and closes the events channel.
+func (s *Subscription) Unsubscribe() {
+	s.bus.remove(s)
+}
+
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	dropped     *prometheus.CounterVec
+}
+
+func newEventBus(metricPrefix string) *eventBus {
+	return &eventBus{
+		subscribers: map[*Subscription]struct{}{},
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: metricPrefix + "lifecycle_events_dropped_total",
+			Help: "Amount of container lifecycle events dropped because subscriber queue was full",
+		}, []string{"subscriber"}),
+	}
+}
+
+func (b *eventBus) add(name string, opts SubscribeOptions, snapshot []LifecycleEvent) *Subscription {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultSubscriptionBuffer
+	}
+	s := &Subscription{
+		name: name,
+		ch:   make(chan LifecycleEvent, opts.Buffer+len(snapshot)),
+		bus:  b,
+	}
+	if len(opts.Types) > 0 {
+		s.types = map[EventType]bool{}
+		for _, t := range opts.Types {
+			s.types[t] = true
+		}
+	}
+	for _, e := range snapshot {
+		s.send(e)
+	}
+
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+func (b *eventBus) remove(s *Subscription) {
+	b.mu.Lock()
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.ch)
+	}
+	b.mu.Unlock()
+}
+
+// publish delivers event to all subscribers without blocking.
+func (b *eventBus) publish(e LifecycleEvent) {
+	b.mu.RLock()
+	for s := range b.subscribers {
+		s.send(e)
+	}
+	b.mu.RUnlock()
+}
+
+func (s *Subscription) send(e LifecycleEvent) {
+	if s.types != nil && !s.types[e.Type] {
+		return
+	}
+	select {
+	case s.ch <- e:
+	default:
+		s.bus.dropped.WithLabelValues(s.name).Inc()
+	}
+}
+
+// Subscribe creates a subscription to container lifecycle events, name identifies the subscriber in metrics.
+func (m *InmemoryStorage) Subscribe(name string, opts SubscribeOptions) *Subscription {
+	// storage lock guarantees no container is started or stopped between snapshot and subscription
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var snapshot []LifecycleEvent
+	if opts.Snapshot {
+		now := time.Now()
+		for _, c := range m.containers {
+			if !c.Running {
+				continue
+			}
+			snapshot = append(snapshot, LifecycleEvent{Type: ContainerStarted, Container: c, Time: now, Snapshot: true})
+		}
+	}
+	return m.bus.add(name, opts, snapshot)
+}
@@ -1,5 +1,7 @@
 package storages
 
+import "time"
+
 type Container struct {
 	ID              string
 	Ecs             bool
@@ -7,6 +9,25 @@ type Container struct {
 	Service         string
 	Address         string
 	Revisions       string
+	RevisionsMap    map[string]string // component name to revision
+	Labels          map[string]string
 	MemoryStatsPath []string
 	CPUStatsPath    []string
+	LoadedAt        time.Time
+	Name            string
+	Running         bool
+	Paused          bool
+	MemoryLimit     int64 // bytes, zero when unlimited
+	NanoCPUs        int64
+	CPUShares       int64
+	RestartCount    int  // restarts by docker restart policy
+	Discovered      bool // found by listing containers, not by start event
+	StartedAt       time.Time
+	Mounts          []Mount
+}
+
+// Mount is a volume or a bind mount of the container.
+type Mount struct {
+	Source      string // path on the host
+	Destination string // path inside the container
 }
@@ -6,122 +6,372 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gojuno/aleh/httpclient"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+const (
+	// DefaultRevisionLabelPrefix is the prefix of container labels holding component revisions.
+	DefaultRevisionLabelPrefix = "net.junolab.revision"
+	// DefaultEventsReconnectMaxDelay is the maximum delay between events stream reconnects.
+	DefaultEventsReconnectMaxDelay = 30 * time.Second
+	// DefaultEventWorkers is the default amount of concurrent event handlers.
+	DefaultEventWorkers = 8
+	// DefaultInspectConcurrency is the default limit of concurrent container inspect requests.
+	DefaultInspectConcurrency = 4
+	// DefaultForgetGracePeriod is the default delay before destroyed container is forgotten.
+	DefaultForgetGracePeriod = time.Minute
+
+	eventsReconnectMinDelay = 100 * time.Millisecond
+
+	// statusDiscover is the status of synthetic events loading containers found by listing,
+	// they are queued with docker events so that loads never race with events of the same container
+	statusDiscover = "aleh:discover"
+	// statusResyncStop and statusResyncRemove are statuses of synthetic events of resync corrections
+	statusResyncStop   = "aleh:resync-stop"
+	statusResyncRemove = "aleh:resync-remove"
+
+	// transient failures of docker requests are retried
+	requestAttempts      = 3
+	requestRetryMinDelay = 100 * time.Millisecond
+	requestRetryMaxDelay = time.Second
+)
+
+// Options configures InmemoryStorage.
+type Options struct {
+	// RevisionLabelPrefix is the prefix of container labels holding component revisions,
+	// DefaultRevisionLabelPrefix is used when empty.
+	RevisionLabelPrefix string
+	// ResyncInterval is the period of full containers list reconciliation, zero disables it.
+	ResyncInterval time.Duration
+	// EventsReconnectMaxDelay limits the backoff between events stream reconnects,
+	// DefaultEventsReconnectMaxDelay is used when zero.
+	EventsReconnectMaxDelay time.Duration
+	// EventWorkers is the amount of concurrent event handlers, events of one container are always handled in order.
+	EventWorkers int
+	// InspectConcurrency limits concurrent container inspect requests.
+	InspectConcurrency int
+	// ForgetGracePeriod is the delay before destroyed container is forgotten.
+	ForgetGracePeriod time.Duration
+	MetricPrefix      string
+}
+
 type InmemoryStorage struct {
-	alive     map[string]Container
-	mu        sync.RWMutex
-	httpc     http.Client
-	listeners []chan<- Container
+	// containers are kept after they stop until destroy, running ones are marked with Running
+	containers map[string]Container
+	mu         sync.RWMutex
+	docker     *httpclient.Client
+	opts       Options
+	queue      *eventQueue
+	bus        *eventBus
+	inspects   chan struct{}
+	// loaded is closed when the initial containers list is loaded
+	loaded chan struct{}
+	// connected is set to 1 while events stream is connected
+	connected int32
+
+	resyncCorrections *prometheus.CounterVec
+	eventsReconnects  prometheus.Counter
+	eventsConnected   prometheus.Gauge
+	dockerUp          prometheus.Gauge
+	eventsReceived    *prometheus.CounterVec
+	eventDecodeErrors prometheus.Counter
+	inspectErrors     prometheus.Counter
+	trackedDesc       *prometheus.Desc
 }
 
-type containerID struct {
-	ID string `json:"Id"`
+type containerSummary struct {
+	ID    string `json:"Id"`
+	State string `json:"State"`
+}
+
+// running reports whether listed container is running, paused containers are running too.
+func (c containerSummary) running() bool {
+	return c.State == "" || c.State == "running" || c.State == "paused"
+}
+
+type actor struct {
+	ID         string            `json:"ID"`
+	Attributes map[string]string `json:"Attributes"`
 }
 
 type event struct {
-	Message string `json:"message"`
-	Status  string `json:"status"`
-	ID      string `json:"id"`
-	Action  string `json:"action"`
-	Type    string `json:"type"`
+	Message  string `json:"message"`
+	Status   string `json:"status"`
+	ID       string `json:"id"`
+	Action   string `json:"action"`
+	Type     string `json:"type"`
+	Actor    actor  `json:"Actor"`
+	TimeNano int64  `json:"timeNano"`
 }
 
-func New(ctx context.Context, socketPath string) *InmemoryStorage {
+func New(ctx context.Context, docker *httpclient.Client, opts Options) *InmemoryStorage {
+	if opts.RevisionLabelPrefix == "" {
+		opts.RevisionLabelPrefix = DefaultRevisionLabelPrefix
+	}
+	if opts.EventsReconnectMaxDelay <= 0 {
+		opts.EventsReconnectMaxDelay = DefaultEventsReconnectMaxDelay
+	}
+	if opts.EventWorkers <= 0 {
+		opts.EventWorkers = DefaultEventWorkers
+	}
+	if opts.InspectConcurrency <= 0 {
+		opts.InspectConcurrency = DefaultInspectConcurrency
+	}
+	if opts.ForgetGracePeriod <= 0 {
+		opts.ForgetGracePeriod = DefaultForgetGracePeriod
+	}
 	inmemoryStorage := &InmemoryStorage{
-		alive: map[string]Container{},
-		httpc: httpclient.SocketClient(socketPath),
+		containers: map[string]Container{},
+		docker:     docker,
+		opts:       opts,
+		queue:      newEventQueue(opts.MetricPrefix, opts.EventWorkers),
+		bus:        newEventBus(opts.MetricPrefix),
+		inspects:   make(chan struct{}, opts.InspectConcurrency),
+		loaded:     make(chan struct{}),
+		resyncCorrections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: opts.MetricPrefix + "resync_corrections_total",
+			Help: "Amount of containers added or removed by periodic resync because events were missed",
+		}, []string{"action"}),
+		eventsReconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: opts.MetricPrefix + "events_stream_reconnects_total",
+			Help: "Amount of docker events stream reconnects",
+		}),
+		eventsConnected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: opts.MetricPrefix + "events_stream_connected",
+			Help: "Whether docker events stream is connected",
+		}),
+		dockerUp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: opts.MetricPrefix + "docker_up",
+			Help: "Whether the last request to docker daemon succeeded",
+		}),
+		eventsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: opts.MetricPrefix + "docker_events_total",
+			Help: "Amount of docker events received",
+		}, []string{"type", "action"}),
+		eventDecodeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: opts.MetricPrefix + "docker_event_decode_errors_total",
+			Help: "Amount of docker events failed to decode",
+		}),
+		inspectErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: opts.MetricPrefix + "container_inspect_errors_total",
+			Help: "Amount of failed container inspect requests",
+		}),
+		trackedDesc: prometheus.NewDesc(opts.MetricPrefix+"tracked_containers", "Amount of containers known to aleh by state", []string{"state"}, nil),
 	}
 
+	inmemoryStorage.queue.run(ctx, inmemoryStorage.handleEvent)
 	go inmemoryStorage.listenEvents(ctx)
-	go inmemoryStorage.loadContainers(ctx, socketPath)
+	go inmemoryStorage.loadContainers(ctx)
+	if opts.ResyncInterval > 0 {
+		go inmemoryStorage.resync(ctx, opts.ResyncInterval)
+	}
 
 	return inmemoryStorage
 }
 
-func (m *InmemoryStorage) loadContainers(ctx context.Context, socketPath string) {
-	dockerContainersPath := "http://localhost" + "/containers/json"
-	req, err := http.NewRequest("GET", dockerContainersPath, nil)
-	if err != nil {
-		log.Printf("ERROR: failed to build http req for containers list%s: %v", dockerContainersPath, err)
-		return
+// loadContainers loads running containers retrying until the list is received, storage is not ready before.
+func (m *InmemoryStorage) loadContainers(ctx context.Context) {
+	b := newBackoff(eventsReconnectMinDelay, m.opts.EventsReconnectMaxDelay)
+	for {
+		containers, err := m.listContainers(ctx, false)
+		if err == nil {
+			m.loadAll(ctx, containers)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		delay := b.next()
+		log.Printf("ERROR: failed to list containers, retry in %v: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
 	}
+}
 
-	req = req.WithContext(ctx)
-	resp, err := m.httpc.Do(req)
-	if err != nil {
-		log.Printf("ERROR: failed to do http req to %s: %v", dockerContainersPath, err)
+// loadAll queues loading of the listed containers and waits until they are loaded.
+func (m *InmemoryStorage) loadAll(ctx context.Context, containers []containerSummary) {
+	loaded := make(chan struct{})
+	pending := int32(len(containers))
+	if pending == 0 {
+		close(loaded)
+	}
+	for _, c := range containers {
+		m.queue.push(event{ID: c.ID, Status: statusDiscover}, func() {
+			if atomic.AddInt32(&pending, -1) == 0 {
+				close(loaded)
+			}
+		})
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("ERROR: failed to read containers/json resp: %v", err)
-		return
+	select {
+	case <-ctx.Done():
+	case <-loaded:
+		close(m.loaded)
+		log.Printf("DEBUG: loaded %d containers", len(containers))
 	}
+}
 
-	ids := []containerID{}
-	if err := json.Unmarshal(body, &ids); err != nil {
-		log.Printf("ERROR: failed to unmarshall containers/json body %s: %v", string(body), err)
-		return
+// Loaded returns the channel closed when the initial containers list is loaded,
+// events of the loaded containers are published before it is closed.
+func (m *InmemoryStorage) Loaded() <-chan struct{} {
+	return m.loaded
+}
+
+// Ready returns an error until the initial containers list is loaded and while events stream is disconnected.
+func (m *InmemoryStorage) Ready() error {
+	select {
+	case <-m.loaded:
+	default:
+		return errors.New("containers list is not loaded")
+	}
+	if atomic.LoadInt32(&m.connected) == 0 {
+		return errors.New("docker events stream is not connected")
+	}
+	return nil
+}
+
+// setDockerUp reports result of a docker request, docker is up if it responded with any status.
+// Requests cancelled by ctx are ignored.
+func (m *InmemoryStorage) setDockerUp(ctx context.Context, err error) {
+	switch {
+	case !httpclient.IsUnreachable(err):
+		m.dockerUp.Set(1)
+	case ctx.Err() == nil:
+		m.dockerUp.Set(0)
+	}
+}
+
+// listContainers returns running containers or all of them.
+func (m *InmemoryStorage) listContainers(ctx context.Context, all bool) ([]containerSummary, error) {
+	path := "/containers/json"
+	if all {
+		path += "?all=1"
+	}
+	containers := []containerSummary{}
+	if err := m.get(ctx, path, &containers); err != nil {
+		return nil, errors.Wrap(err, "failed to list containers")
 	}
+	return containers, nil
+}
 
-	for _, id := range ids {
-		go func(id string) {
-			m.loadContainer(ctx, id)
-		}(id.ID)
+// get requests docker API path decoding JSON response into v, transient failures are retried with backoff.
+func (m *InmemoryStorage) get(ctx context.Context, path string, v interface{}) error {
+	b := newBackoff(requestRetryMinDelay, requestRetryMaxDelay)
+	for attempt := 1; ; attempt++ {
+		err := m.docker.GetJSON(ctx, path, v)
+		m.setDockerUp(ctx, err)
+		if err == nil || attempt >= requestAttempts || !httpclient.IsTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		delay := b.next()
+		log.Printf("DEBUG: retry %s in %v: %v", path, delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
 	}
 }
 
 func (m *InmemoryStorage) listenEvents(ctx context.Context) {
+	// events since the latest handled one are replayed after reconnect
+	var last event
+	b := newBackoff(eventsReconnectMinDelay, m.opts.EventsReconnectMaxDelay)
 	for {
-		dockerEventsPath := "http://localhost" + "/events"
-		req, err := http.NewRequest("GET", dockerEventsPath, nil)
+		err := m.readEvents(ctx, &last, b.reset)
+		m.eventsConnected.Set(0)
+		atomic.StoreInt32(&m.connected, 0)
+		if ctx.Err() != nil {
+			log.Printf("ERROR: got err from context during reading stream: %v", ctx.Err())
+			return
+		}
 		if err != nil {
-			log.Printf("ERROR: failed to build http req for events stream%s: %v", dockerEventsPath, err)
+			log.Printf("ERROR: events stream failed: %v", err)
 		}
 
-		log.Printf("DEBUG: connect to stream %s", dockerEventsPath)
-		req = req.WithContext(ctx)
-		resp, err := m.httpc.Do(req)
-		if err != nil {
-			log.Printf("ERROR: failed to do http req to %s: %v", dockerEventsPath, err)
-			continue
+		delay := b.next()
+		log.Printf("DEBUG: reconnect to events stream in %v", delay)
+		m.eventsReconnects.Inc()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
 		}
+	}
+}
 
-		scanner := bufio.NewScanner(resp.Body)
+// readEvents reads events stream until it is closed. connected is called once the stream is established.
+func (m *InmemoryStorage) readEvents(ctx context.Context, last *event, connected func()) error {
+	query := url.Values{}
+	query.Set("filters", `{"type":["container"]}`)
+	replay := newReplayFilter(*last)
+	if last.TimeNano > 0 {
+		query.Set("since", fmt.Sprintf("%d.%09d", last.TimeNano/int64(time.Second), last.TimeNano%int64(time.Second)))
+	}
+	dockerEventsPath := "/events?" + query.Encode()
 
-		log.Printf("DEBUG: start to read response body")
-		for scanner.Scan() {
-			if ctx.Err() != nil {
-				log.Printf("ERROR: got err from context during reading stream: %v", ctx.Err())
-				return
-			}
+	log.Printf("DEBUG: connect to stream %s", dockerEventsPath)
+	resp, err := m.docker.Get(ctx, dockerEventsPath)
+	m.setDockerUp(ctx, err)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to events stream")
+	}
+	defer resp.Body.Close()
 
-			chunkBytes := bytes.TrimRight(scanner.Bytes(), "\r\n")
+	m.eventsConnected.Set(1)
+	atomic.StoreInt32(&m.connected, 1)
+	connected()
+	scanner := bufio.NewScanner(resp.Body)
 
-			e := event{}
-			if err := json.Unmarshal(chunkBytes, &e); err != nil {
-				log.Printf("ERROR: failed to decode event %s: %v", string(chunkBytes), err.Error())
-				continue
-			}
-			go m.handleEvent(ctx, e)
+	log.Printf("DEBUG: start to read response body")
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		chunkBytes := bytes.TrimRight(scanner.Bytes(), "\r\n")
+
+		e := event{}
+		if err := json.Unmarshal(chunkBytes, &e); err != nil {
+			m.eventDecodeErrors.Inc()
+			log.Printf("ERROR: failed to decode event %s: %v", string(chunkBytes), err.Error())
+			continue
 		}
-		log.Printf("DEBUG: finished body reading")
-		if scanner.Err() != nil {
-			log.Printf("ERROR: got err from scanner during reading: %v", scanner.Err())
+		action := e.Action
+		if action == "" {
+			action = e.Status
 		}
-		resp.Body.Close()
+		// health_status actions carry the status after colon
+		m.eventsReceived.WithLabelValues(e.Type, strings.SplitN(action, ":", 2)[0]).Inc()
+		// replayed events could be already handled before reconnect
+		if replay.handled(e) {
+			continue
+		}
+		if e.TimeNano >= last.TimeNano {
+			*last = e
+		}
+		m.queue.push(e, nil)
+	}
+	log.Printf("DEBUG: finished body reading")
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "got err from scanner during reading")
 	}
+	return nil
 }
 
 func (m *InmemoryStorage) HttpHandler() http.HandlerFunc {
@@ -137,16 +387,121 @@ func (m *InmemoryStorage) HttpHandler() http.HandlerFunc {
 	}
 }
 
+// Describe prometheus.Collector interface implementation
+func (m *InmemoryStorage) Describe(ch chan<- *prometheus.Desc) {
+	m.resyncCorrections.Describe(ch)
+	ch <- m.eventsReconnects.Desc()
+	ch <- m.eventsConnected.Desc()
+	ch <- m.dockerUp.Desc()
+	m.eventsReceived.Describe(ch)
+	ch <- m.eventDecodeErrors.Desc()
+	ch <- m.inspectErrors.Desc()
+	ch <- m.trackedDesc
+	m.queue.Describe(ch)
+	m.bus.dropped.Describe(ch)
+}
+
+// Collect prometheus.Collector interface implementation
+func (m *InmemoryStorage) Collect(ch chan<- prometheus.Metric) {
+	m.resyncCorrections.Collect(ch)
+	ch <- m.eventsReconnects
+	ch <- m.eventsConnected
+	ch <- m.dockerUp
+	m.eventsReceived.Collect(ch)
+	ch <- m.eventDecodeErrors
+	ch <- m.inspectErrors
+	m.collectTracked(ch)
+	m.queue.Collect(ch)
+	m.bus.dropped.Collect(ch)
+}
+
+func (m *InmemoryStorage) collectTracked(ch chan<- prometheus.Metric) {
+	states := map[string]int{"running": 0, "paused": 0, "stopped": 0}
+	m.mu.RLock()
+	for _, c := range m.containers {
+		switch {
+		case c.Paused:
+			states["paused"]++
+		case c.Running:
+			states["running"]++
+		default:
+			states["stopped"]++
+		}
+	}
+	m.mu.RUnlock()
+	for state, n := range states {
+		ch <- prometheus.MustNewConstMetric(m.trackedDesc, prometheus.GaugeValue, float64(n), state)
+	}
+}
+
 func (m *InmemoryStorage) handleEvent(ctx context.Context, event event) {
 	log.Printf("DEBUG: handle event %+v", event)
-	switch event.Status {
-	case "start":
-		m.loadContainer(ctx, event.ID)
-	case "kill", "die", "stop":
-		m.removeContainer(event.ID)
+	switch {
+	case event.Status == statusDiscover:
+		m.loadContainer(ctx, event.ID, ContainerDiscovered)
+	case event.Status == statusResyncStop, event.Status == statusResyncRemove:
+		m.correct(event)
+	case event.Status == "start":
+		m.loadContainer(ctx, event.ID, ContainerStarted)
+	case event.Status == "kill":
+		// container may ignore or handle the signal, die event follows when it really stops
+		log.Printf("DEBUG: container %s got signal %s", event.ID, event.Actor.Attributes["signal"])
+	case event.Status == "die":
+		m.notifyStopped(event, ContainerDied)
+	case event.Status == "stop":
+		m.stopContainer(event.ID)
+	case event.Status == "pause", event.Status == "unpause":
+		m.setPaused(event.ID, event.Status == "pause")
+	case event.Status == "rename", event.Status == "update":
+		m.loadContainer(ctx, event.ID, ContainerUpdated)
+	case event.Status == "create":
+		m.notify(event, ContainerCreated)
+	case event.Status == "destroy":
+		m.notifyStopped(event, ContainerDestroyed)
+		time.AfterFunc(m.opts.ForgetGracePeriod, func() {
+			m.removeContainer(event.ID)
+		})
+	case event.Status == "oom":
+		m.notify(event, ContainerOOM)
+	case strings.HasPrefix(event.Status, "health_status"):
+		m.notify(event, ContainerHealthChanged)
 	}
 }
 
+// notify publishes lifecycle event of the known container or the one described by event attributes.
+func (m *InmemoryStorage) notify(e event, t EventType) {
+	m.mu.RLock()
+	le := m.lifecycleEvent(e, t)
+	m.mu.RUnlock()
+	m.bus.publish(le)
+}
+
+// notifyStopped publishes lifecycle event and marks the container as not running,
+// publishing under the lock keeps subscription snapshots consistent.
+func (m *InmemoryStorage) notifyStopped(e event, t EventType) {
+	m.mu.Lock()
+	m.bus.publish(m.lifecycleEvent(e, t))
+	m.markStopped(e.ID)
+	m.mu.Unlock()
+}
+
+// lifecycleEvent describes event of type t, it should be called under lock.
+func (m *InmemoryStorage) lifecycleEvent(e event, t EventType) LifecycleEvent {
+	c, ok := m.containers[e.ID]
+	if !ok {
+		c = m.parse(e.ID, containerInfo{Config: containerConfig{Labels: e.Actor.Attributes}})
+	}
+
+	le := LifecycleEvent{Type: t, Container: c, Time: time.Unix(0, e.TimeNano)}
+	switch t {
+	case ContainerDied:
+		le.ExitCode, _ = strconv.Atoi(e.Actor.Attributes["exitCode"])
+	case ContainerHealthChanged:
+		le.Health = strings.TrimSpace(strings.TrimPrefix(e.Status, "health_status:"))
+	}
+	return le
+}
+
 type containerConfig struct {
 	Labels map[string]string `json:"Labels"`
 }
@@ -160,22 +515,35 @@ type networkSettings struct {
 	Networks map[string]network `json:"Networks"`
 }
 
+type containerState struct {
+	Running   bool      `json:"Running"`
+	Paused    bool      `json:"Paused"`
+	StartedAt time.Time `json:"StartedAt"`
+}
+
 type hostConfig struct {
 	CgroupParent string `json:"CgroupParent"`
+	Memory       int64  `json:"Memory"`
+	NanoCPUs     int64  `json:"NanoCpus"`
+	CPUShares    int64  `json:"CpuShares"`
 }
 
 type containerInfo struct {
 	ID              string          `json:"Id"`
+	Name            string          `json:"Name"`
+	RestartCount    int             `json:"RestartCount"`
+	State           containerState  `json:"State"`
 	Config          containerConfig `json:"config"`
 	NetworkSettings networkSettings `json:"NetworkSettings"`
 	HostConfig      hostConfig      `json:"HostConfig"`
+	Mounts          []Mount         `json:"Mounts"`
 }
 
 func (m *InmemoryStorage) AliveECSContainers() map[string]Container {
 	m.mu.RLock()
-	res := make(map[string]Container, len(m.alive))
-	for k, v := range m.alive {
-		if v.Ecs {
+	res := make(map[string]Container, len(m.containers))
+	for k, v := range m.containers {
+		if v.Ecs && v.Running {
 			res[k] = v
 		}
 	}
@@ -183,63 +551,89 @@ func (m *InmemoryStorage) AliveECSContainers() map[string]Container {
 	return res
 }
 
-func (m *InmemoryStorage) AddContainerListener(l chan<- Container) {
+// removeContainer forgets the container unless it was started again.
+func (m *InmemoryStorage) removeContainer(containerID string) {
+	m.mu.Lock()
+	if c, ok := m.containers[containerID]; ok && !c.Running {
+		delete(m.containers, containerID)
+	}
+	m.mu.Unlock()
+}
+
+// stopContainer marks the container as not running.
+func (m *InmemoryStorage) stopContainer(containerID string) {
 	m.mu.Lock()
-	m.listeners = append(m.listeners, l)
+	m.markStopped(containerID)
 	m.mu.Unlock()
 }
 
-func (m *InmemoryStorage) removeContainer(containerID string) {
+// markStopped marks the container as not running, it should be called under the write lock.
+func (m *InmemoryStorage) markStopped(containerID string) {
+	if c, ok := m.containers[containerID]; ok {
+		c.Running = false
+		c.Paused = false
+		m.containers[containerID] = c
+	}
+}
+
+func (m *InmemoryStorage) setPaused(containerID string, paused bool) {
 	m.mu.Lock()
-	delete(m.alive, containerID)
+	if c, ok := m.containers[containerID]; ok {
+		c.Paused = paused
+		m.containers[containerID] = c
+	}
 	m.mu.Unlock()
 }
 
-func (m *InmemoryStorage) loadContainer(ctx context.Context, containerID string) {
+// loadContainer inspects the container, stores it and notifies subscribers with event of type t.
+func (m *InmemoryStorage) loadContainer(ctx context.Context, containerID string, t EventType) {
 
 	info, err := m.load(ctx, containerID)
+	if httpclient.IsNotFound(err) {
+		log.Printf("DEBUG: container %s is already removed, skipping", containerID)
+		return
+	}
 	if err != nil {
-		log.Printf("ERROR: failed to load container: %v", err.Error())
+		// half loaded container is never stored, resync or the next event loads it again
+		if ctx.Err() == nil {
+			m.inspectErrors.Inc()
+			log.Printf("ERROR: failed to load container: %v", err.Error())
+		}
+		return
+	}
+	if !info.State.Running && t != ContainerUpdated {
+		log.Printf("DEBUG: container %s is not running anymore, skipping", containerID)
+		return
 	}
 
 	container := m.parse(containerID, info)
+	container.LoadedAt = time.Now()
+	container.Discovered = t == ContainerDiscovered
+	if t == ContainerUpdated {
+		m.mu.RLock()
+		container.Discovered = m.containers[containerID].Discovered
+		m.mu.RUnlock()
+	}
 
+	// publishing under the lock keeps subscription snapshots consistent
 	m.mu.Lock()
-	m.alive[containerID] = container
+	m.containers[containerID] = container
+	m.bus.publish(LifecycleEvent{Type: t, Container: container, Time: container.LoadedAt})
 	m.mu.Unlock()
-
-	// notify listeners with new container in non blocking way
-	for _, c := range m.listeners {
-		select {
-		case c <- container:
-		default:
-		}
-	}
 }
 
-func (m *InmemoryStorage) load(ctx context.Context, containerID string) (info containerInfo, err error) {
-	containerJSONPath := "http://localhost/containers/%s/json"
-	req, err := http.NewRequest("GET", fmt.Sprintf(containerJSONPath, containerID), nil)
-	if err != nil {
-		return info, errors.Wrapf(err, "failed to build http req %s", containerJSONPath)
-	}
-
-	req = req.WithContext(ctx)
-	resp, err := m.httpc.Do(req)
-	if err != nil {
-		panic(errors.Wrapf(err, "failed to get container %s json", containerID))
-	}
-	defer resp.Body.Close()
-
-	respJson, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return info, errors.Wrapf(err, "failed to read container %s json resp body", containerID)
+func (m *InmemoryStorage) load(ctx context.Context, containerID string) (containerInfo, error) {
+	select {
+	case m.inspects <- struct{}{}:
+		defer func() { <-m.inspects }()
+	case <-ctx.Done():
+		return containerInfo{}, ctx.Err()
 	}
 
-	if err := json.Unmarshal(respJson, &info); err != nil {
-		return info, errors.Wrapf(err, "failed to unmarshall container %s", containerID)
+	info := containerInfo{}
+	if err := m.get(ctx, fmt.Sprintf("/containers/%s/json", containerID), &info); err != nil {
+		return containerInfo{}, errors.Wrapf(err, "failed to inspect container %s", containerID)
 	}
-
 	return info, nil
 }
 
@@ -249,6 +643,18 @@ func (m *InmemoryStorage) parse(containerID string, ci containerInfo) Container
 		Container: ci.Config.Labels["com.amazonaws.ecs.container-name"],
 		Service:   ci.Config.Labels["com.amazonaws.ecs.task-definition-family"],
 		Address:   "172.17.42.1",
+		Labels:    ci.Config.Labels,
+
+		Name:        strings.TrimPrefix(ci.Name, "/"),
+		Running:     ci.State.Running,
+		Paused:      ci.State.Paused,
+		MemoryLimit: ci.HostConfig.Memory,
+		NanoCPUs:    ci.HostConfig.NanoCPUs,
+		CPUShares:   ci.HostConfig.CPUShares,
+
+		RestartCount: ci.RestartCount,
+		StartedAt:    ci.State.StartedAt,
+		Mounts:       ci.Mounts,
 	}
 	c.Ecs = c.Container != "" && c.Service != ""
 	if bridge, ok := ci.NetworkSettings.Networks["bridge"]; ok && bridge.IPAddress != "" {
@@ -256,13 +662,17 @@ func (m *InmemoryStorage) parse(containerID string, ci containerInfo) Container
 	}
 	revisions := []string{}
 	for label, revision := range ci.Config.Labels {
-		if !strings.HasPrefix(label, "net.junolab.revision") {
+		if !strings.HasPrefix(label, m.opts.RevisionLabelPrefix) {
 			continue
 		}
 
 		parts := strings.Split(label, ".")
 		revisionName := parts[len(parts)-1]
 		revisions = append(revisions, revisionName+"="+revision)
+		if c.RevisionsMap == nil {
+			c.RevisionsMap = map[string]string{}
+		}
+		c.RevisionsMap[revisionName] = revision
 	}
 	if len(revisions) > 0 {
 		sort.Strings(revisions)
@@ -0,0 +1,166 @@
+package storages
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type queuedEvent struct {
+	event
+	queued time.Time
+	// done is called after the event is handled
+	done func()
+}
+
+// eventQueue delivers events of the same container in order,
+// events of different containers are handled concurrently by shards.
+type eventQueue struct {
+	shards    []*eventShard
+	depth     prometheus.Gauge
+	latency   prometheus.Histogram
+	coalesced prometheus.Counter
+}
+
+type eventShard struct {
+	mu     sync.Mutex
+	events []queuedEvent
+	wake   chan struct{}
+}
+
+func newEventQueue(metricPrefix string, shards int) *eventQueue {
+	q := &eventQueue{
+		depth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: metricPrefix + "event_queue_depth",
+			Help: "Amount of docker events waiting to be handled",
+		}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    metricPrefix + "event_processing_seconds",
+			Help:    "Time from docker event receiving till it is handled",
+			Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30},
+		}),
+		coalesced: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: metricPrefix + "event_queue_coalesced_total",
+			Help: "Amount of docker events dropped because a pending event of the container leads to the same state",
+		}),
+	}
+	for i := 0; i < shards; i++ {
+		q.shards = append(q.shards, &eventShard{wake: make(chan struct{}, 1)})
+	}
+	return q
+}
+
+// push adds event to the queue of its container unless it repeats the last pending one, done is called after it is handled.
+// done of a redundant event is called after the pending one is handled.
+func (q *eventQueue) push(e event, done func()) {
+	h := fnv.New32a()
+	h.Write([]byte(e.ID))
+	s := q.shards[h.Sum32()%uint32(len(q.shards))]
+
+	s.mu.Lock()
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if s.events[i].ID != e.ID {
+			continue
+		}
+		if coalescable(s.events[i].event, e) {
+			s.events[i].done = chain(s.events[i].done, done)
+			s.mu.Unlock()
+			q.coalesced.Inc()
+			return
+		}
+		break
+	}
+	s.events = append(s.events, queuedEvent{event: e, queued: time.Now(), done: done})
+	s.mu.Unlock()
+	q.depth.Inc()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run handles queued events until ctx is done.
+func (q *eventQueue) run(ctx context.Context, handle func(context.Context, event)) {
+	for _, s := range q.shards {
+		go q.runShard(ctx, s, handle)
+	}
+}
+
+func (q *eventQueue) runShard(ctx context.Context, s *eventShard, handle func(context.Context, event)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		}
+
+		for {
+			s.mu.Lock()
+			if len(s.events) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			e := s.events[0]
+			s.events = s.events[1:]
+			s.mu.Unlock()
+			q.depth.Dec()
+
+			handle(ctx, e.event)
+			q.latency.Observe(time.Since(e.queued).Seconds())
+			if e.done != nil {
+				e.done()
+			}
+		}
+	}
+}
+
+func chain(a, b func()) func() {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	return func() {
+		a()
+		b()
+	}
+}
+
+// coalescable reports whether e is redundant after the pending event p of the same container.
+// Only container reloads and repeated kill signals are, one-off notifications like oom and
+// health status changes are delivered every time.
+func coalescable(p, e event) bool {
+	switch effect(e) {
+	case "reload", statusDiscover, "kill":
+		return effect(p) == effect(e)
+	}
+	return false
+}
+
+// effect returns the kind of container state change caused by event.
+func effect(e event) string {
+	switch e.Status {
+	case "rename", "update":
+		return "reload"
+	}
+	return e.Status
+}
+
+// Describe prometheus.Collector interface implementation
+func (q *eventQueue) Describe(ch chan<- *prometheus.Desc) {
+	ch <- q.depth.Desc()
+	ch <- q.latency.Desc()
+	ch <- q.coalesced.Desc()
+}
+
+// Collect prometheus.Collector interface implementation
+func (q *eventQueue) Collect(ch chan<- prometheus.Metric) {
+	ch <- q.depth
+	ch <- q.latency
+	ch <- q.coalesced
+}
@@ -0,0 +1,151 @@
+package storages
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestEventQueue(t *testing.T) {
+	tests := []struct {
+		name      string
+		events    []event
+		handled   map[string][]string
+		coalesced float64
+	}{
+		{
+			name:    "events of a container are handled in order",
+			events:  []event{{ID: "c1", Status: "start"}, {ID: "c1", Status: "die"}, {ID: "c1", Status: "start"}, {ID: "c1", Status: "destroy"}},
+			handled: map[string][]string{"c1": {"start", "die", "start", "destroy"}},
+		},
+		{
+			name:      "repeated kill is coalesced with the pending one",
+			events:    []event{{ID: "c1", Status: "kill"}, {ID: "c1", Status: "kill"}},
+			handled:   map[string][]string{"c1": {"kill"}},
+			coalesced: 1,
+		},
+		{
+			name:      "discovery is coalesced with the pending one",
+			events:    []event{{ID: "c1", Status: statusDiscover}, {ID: "c1", Status: statusDiscover}},
+			handled:   map[string][]string{"c1": {statusDiscover}},
+			coalesced: 1,
+		},
+		{
+			name:    "one-off notifications are not coalesced",
+			events:  []event{{ID: "c1", Status: "oom"}, {ID: "c1", Status: "oom"}, {ID: "c1", Status: "health_status: unhealthy"}, {ID: "c1", Status: "health_status: unhealthy"}},
+			handled: map[string][]string{"c1": {"oom", "oom", "health_status: unhealthy", "health_status: unhealthy"}},
+		},
+		{
+			name:    "state changes are not coalesced",
+			events:  []event{{ID: "c1", Status: "start"}, {ID: "c1", Status: "start"}},
+			handled: map[string][]string{"c1": {"start", "start"}},
+		},
+		{
+			name:      "rename and update lead to the same state",
+			events:    []event{{ID: "c1", Status: "rename"}, {ID: "c1", Status: "update"}, {ID: "c1", Status: "rename"}},
+			handled:   map[string][]string{"c1": {"rename"}},
+			coalesced: 2,
+		},
+		{
+			name:    "only the last pending event of the container is coalesced",
+			events:  []event{{ID: "c1", Status: "kill"}, {ID: "c1", Status: "die"}, {ID: "c1", Status: "kill"}},
+			handled: map[string][]string{"c1": {"kill", "die", "kill"}},
+		},
+		{
+			name:      "events of other containers do not prevent coalescing",
+			events:    []event{{ID: "c1", Status: "update"}, {ID: "c2", Status: "start"}, {ID: "c1", Status: "rename"}, {ID: "c2", Status: "die"}},
+			handled:   map[string][]string{"c1": {"update"}, "c2": {"start", "die"}},
+			coalesced: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newEventQueue("test_", 4)
+
+			var wg sync.WaitGroup
+			wg.Add(len(tt.events))
+			for _, e := range tt.events {
+				q.push(e, wg.Done)
+			}
+
+			var mu sync.Mutex
+			handled := map[string][]string{}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			q.run(ctx, func(_ context.Context, e event) {
+				mu.Lock()
+				handled[e.ID] = append(handled[e.ID], e.Status)
+				mu.Unlock()
+			})
+
+			waitGroup(t, &wg)
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(handled, tt.handled) {
+				t.Errorf("handled %v, expected %v", handled, tt.handled)
+			}
+			m := &dto.Metric{}
+			q.coalesced.Write(m)
+			if got := m.GetCounter().GetValue(); got != tt.coalesced {
+				t.Errorf("coalesced %v events, expected %v", got, tt.coalesced)
+			}
+		})
+	}
+}
+
+func TestEventQueueOrderUnderLoad(t *testing.T) {
+	q := newEventQueue("test_", 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	handled := map[string][]int64{}
+	q.run(ctx, func(_ context.Context, e event) {
+		mu.Lock()
+		handled[e.ID] = append(handled[e.ID], e.TimeNano)
+		mu.Unlock()
+	})
+
+	// alternating statuses are never coalesced
+	statuses := []string{"start", "die"}
+	ids := []string{"c1", "c2", "c3", "c4", "c5"}
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		for _, id := range ids {
+			wg.Add(1)
+			q.push(event{ID: id, Status: statuses[i%2], TimeNano: int64(i)}, wg.Done)
+		}
+	}
+	waitGroup(t, &wg)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, id := range ids {
+		if len(handled[id]) != 200 {
+			t.Fatalf("handled %d events of %s, expected 200", len(handled[id]), id)
+		}
+		for i, n := range handled[id] {
+			if n != int64(i) {
+				t.Fatalf("event %d of %s handled at position %d", n, id, i)
+			}
+		}
+	}
+}
+
+func waitGroup(t *testing.T, wg *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("events were not handled")
+	}
+}
@@ -0,0 +1,27 @@
+package storages
+
+// replayFilter drops events replayed after events stream reconnect which were handled before it.
+// Only events up to the last handled one are compared, docker may publish live events out of
+// timestamp order and they are never dropped.
+type replayFilter struct {
+	boundary  event
+	replaying bool
+}
+
+// newReplayFilter creates filter of the stream requested since the last handled event.
+func newReplayFilter(last event) *replayFilter {
+	return &replayFilter{boundary: last, replaying: last.TimeNano > 0}
+}
+
+// handled reports whether e was handled before reconnect.
+func (f *replayFilter) handled(e event) bool {
+	if !f.replaying {
+		return false
+	}
+	if e.TimeNano > f.boundary.TimeNano {
+		// replay is over, events after the boundary are new
+		f.replaying = false
+		return false
+	}
+	return e.TimeNano < f.boundary.TimeNano || e.ID == f.boundary.ID && e.Status == f.boundary.Status
+}
@@ -0,0 +1,107 @@
+package storages
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplayFilter(t *testing.T) {
+	last := event{ID: "c1", Status: "start", TimeNano: 100}
+	tests := []struct {
+		name   string
+		last   event
+		events []event
+		passed []string
+	}{
+		{
+			name:   "first connection passes everything",
+			events: []event{{ID: "c1", Status: "start", TimeNano: 100}, {ID: "c2", Status: "die", TimeNano: 50}},
+			passed: []string{"c1 start", "c2 die"},
+		},
+		{
+			name:   "events before the last handled one are dropped",
+			last:   last,
+			events: []event{{ID: "c2", Status: "start", TimeNano: 90}, {ID: "c3", Status: "die", TimeNano: 99}, {ID: "c4", Status: "start", TimeNano: 101}},
+			passed: []string{"c4 start"},
+		},
+		{
+			name:   "only the last handled event is dropped at the same timestamp",
+			last:   last,
+			events: []event{{ID: "c1", Status: "start", TimeNano: 100}, {ID: "c2", Status: "start", TimeNano: 100}, {ID: "c1", Status: "die", TimeNano: 100}},
+			passed: []string{"c2 start", "c1 die"},
+		},
+		{
+			name:   "out of order live events after the boundary are kept",
+			last:   last,
+			events: []event{{ID: "c1", Status: "start", TimeNano: 100}, {ID: "c2", Status: "start", TimeNano: 110}, {ID: "c3", Status: "start", TimeNano: 95}, {ID: "c1", Status: "start", TimeNano: 100}},
+			passed: []string{"c2 start", "c3 start", "c1 start"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newReplayFilter(tt.last)
+			passed := []string{}
+			for _, e := range tt.events {
+				if !f.handled(e) {
+					passed = append(passed, e.ID+" "+e.Status)
+				}
+			}
+			if !reflect.DeepEqual(passed, tt.passed) {
+				t.Errorf("passed %v, expected %v", passed, tt.passed)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max time.Duration
+		// expected delays before jitter, the actual delay is between half of it and it
+		delays []time.Duration
+	}{
+		{
+			name:   "delays double up to max",
+			min:    100 * time.Millisecond,
+			max:    time.Second,
+			delays: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second},
+		},
+		{
+			name:   "max below min is raised to min",
+			min:    time.Second,
+			max:    time.Millisecond,
+			delays: []time.Duration{time.Second, time.Second},
+		},
+		{
+			name:   "shift overflow is limited by max",
+			min:    time.Hour,
+			max:    48 * time.Hour,
+			delays: append([]time.Duration{time.Hour, 2 * time.Hour, 4 * time.Hour, 8 * time.Hour, 16 * time.Hour, 32 * time.Hour}, repeat(48*time.Hour, 40)...),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackoff(tt.min, tt.max)
+			for i, d := range tt.delays {
+				if got := b.next(); got < d/2 || got > d {
+					t.Fatalf("delay %d is %v, expected between %v and %v", i, got, d/2, d)
+				}
+			}
+			b.reset()
+			if got := b.next(); got < tt.delays[0]/2 || got > tt.delays[0] {
+				t.Errorf("delay after reset is %v, expected between %v and %v", got, tt.delays[0]/2, tt.delays[0])
+			}
+		})
+	}
+}
+
+func repeat(d time.Duration, n int) []time.Duration {
+	ds := make([]time.Duration, n)
+	for i := range ds {
+		ds[i] = d
+	}
+	return ds
+}
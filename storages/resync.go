@@ -0,0 +1,112 @@
+package storages
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// resync periodically reconciles known containers with the docker containers list
+// to repair state drifted because of missed events.
+func (m *InmemoryStorage) resync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.reconcile(ctx)
+		}
+	}
+}
+
+func (m *InmemoryStorage) reconcile(ctx context.Context) {
+	started := time.Now()
+	containers, err := m.listContainers(ctx, true)
+	if err != nil {
+		log.Printf("ERROR: failed to list containers for resync: %v", err)
+		return
+	}
+
+	listed := make(map[string]containerSummary, len(containers))
+	for _, c := range containers {
+		listed[c.ID] = c
+	}
+
+	missing := []string{}
+	stopped := []string{}
+	removed := []string{}
+	forgotten := []string{}
+	m.mu.RLock()
+	for _, c := range containers {
+		if known, ok := m.containers[c.ID]; c.running() && (!ok || !known.Running) {
+			missing = append(missing, c.ID)
+		}
+	}
+	for id, c := range m.containers {
+		// containers loaded after the list was requested may be absent in it
+		if !c.LoadedAt.Before(started) {
+			continue
+		}
+		l, ok := listed[id]
+		switch {
+		case !ok && c.Running:
+			removed = append(removed, id)
+		case !ok:
+			// destroyed container, its destroy event could be missed
+			forgotten = append(forgotten, id)
+		case c.Running && !l.running():
+			stopped = append(stopped, id)
+		}
+	}
+	m.mu.RUnlock()
+
+	// corrections are queued with events and skipped if the container changed after listing
+	for _, id := range removed {
+		m.queue.push(event{ID: id, Status: statusResyncRemove, TimeNano: started.UnixNano()}, nil)
+	}
+	for _, id := range forgotten {
+		m.removeContainer(id)
+	}
+	for _, id := range stopped {
+		m.queue.push(event{ID: id, Status: statusResyncStop, TimeNano: started.UnixNano()}, nil)
+	}
+	for _, id := range missing {
+		log.Printf("INFO: resync: found unknown running container %s, loading", id)
+		m.queue.push(event{ID: id, Status: statusDiscover}, nil)
+		m.resyncCorrections.WithLabelValues("added").Inc()
+	}
+}
+
+// correct stops the container missing in the list or not running according to it,
+// subscribers get ContainerDied and for removed containers ContainerDestroyed events.
+func (m *InmemoryStorage) correct(e event) {
+	listedAt := time.Unix(0, e.TimeNano)
+	// publishing under the lock keeps subscription snapshots consistent
+	m.mu.Lock()
+	c, ok := m.containers[e.ID]
+	if !ok || !c.Running || c.LoadedAt.After(listedAt) {
+		m.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	// exit code is unknown because the die event was missed
+	m.bus.publish(LifecycleEvent{Type: ContainerDied, Container: c, Time: now, ExitCode: -1})
+	m.markStopped(e.ID)
+	removed := e.Status == statusResyncRemove
+	if removed {
+		m.bus.publish(LifecycleEvent{Type: ContainerDestroyed, Container: c, Time: now})
+		delete(m.containers, e.ID)
+	}
+	m.mu.Unlock()
+
+	if !removed {
+		log.Printf("INFO: resync: container %s is not running anymore", e.ID)
+		m.resyncCorrections.WithLabelValues("stopped").Inc()
+		return
+	}
+	log.Printf("INFO: resync: container %s does not exist anymore, removing", e.ID)
+	m.resyncCorrections.WithLabelValues("removed").Inc()
+}